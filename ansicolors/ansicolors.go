@@ -53,3 +53,13 @@ func Process(contents []byte) []Block {
 
 	return blocks
 }
+
+// Strip removes all color codes from contents, leaving just the text.
+func Strip(contents []byte) []byte {
+	var result []byte
+	for _, block := range Process(contents) {
+		result = append(result, block.Contents...)
+	}
+
+	return result
+}
@@ -1,47 +1,107 @@
 package main
 
 import (
+	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/frc-2175/benkins/app"
 	"github.com/pelletier/go-toml"
 	"github.com/spf13/cobra"
 )
 
-type Config struct {
-	Name           string
-	ServerUrl      string
-	Password       string
-	SlackToken     string
-	SlackChannelId string
-	RepoUrl        string
-}
-
 func main() {
-	var config Config
+	config := app.RunnerConfig{
+		DefaultTimeout: 5 * time.Minute,
+		MaxTimeout:     time.Hour,
+		Concurrency:    1,
+	}
+
+	if userCacheDir, err := os.UserCacheDir(); err == nil {
+		config.CacheDir = filepath.Join(userCacheDir, "benkins")
+	}
 
 	if configBytes, err := ioutil.ReadFile("config.toml"); err == nil {
 		err = toml.Unmarshal(configBytes, &config)
 		if err != nil {
 			panic(err)
 		}
+
+		if hasNotifySettings(configBytes) {
+			fmt.Println("WARNING: notifications are now sent by the server, so the notify settings in config.toml are ignored. Move them to benkins-server.toml.")
+		}
+		if hasForgeSettings(configBytes) {
+			fmt.Println("WARNING: commit statuses and check runs are now reported by the server, so the github and gitea settings in config.toml are ignored. Move them to the project's settings in benkins-server.toml, and remove the tokens from this runner.")
+		}
 	}
 
 	cmd := &cobra.Command{
 		Use: "benkins-app",
 		Run: func(cmd *cobra.Command, args []string) {
-			app.Main(config.Name, config.ServerUrl, config.Password, config.SlackToken, config.SlackChannelId, config.RepoUrl)
+			if config.RepoUrl != "" {
+				config.Repos = append(config.Repos, app.Repo{URL: config.RepoUrl})
+			}
+
+			app.Main(config)
 		},
 	}
 	cmd.Flags().StringVar(&config.Name, "name", config.Name, "The name to use to identify this client")
 	cmd.Flags().StringVar(&config.ServerUrl, "serverUrl", config.ServerUrl, "The url of the Benkins server")
 	cmd.Flags().StringVar(&config.Password, "password", config.Password, "The Password used for client authentication")
-	cmd.Flags().StringVar(&config.SlackToken, "slackToken", config.SlackToken, "The OAuth token for Slack")
-	cmd.Flags().StringVar(&config.SlackChannelId, "slackChannelId", config.SlackChannelId, "The Slack channel ID (NOT the channel name)")
-	cmd.Flags().StringVar(&config.RepoUrl, "repoUrl", config.RepoUrl, "The HTTPS URL of the Git repo to watch")
+	cmd.Flags().StringVar(&config.CacheDir, "cacheDir", config.CacheDir, "The directory in which to keep local mirrors of watched repos")
+	cmd.Flags().DurationVar(&config.DefaultTimeout, "defaultTimeout", config.DefaultTimeout, "How long jobs may run if benkins.toml does not set a timeout")
+	cmd.Flags().DurationVar(&config.MaxTimeout, "maxTimeout", config.MaxTimeout, "The longest timeout benkins.toml may request, or 0 for no limit")
+	cmd.Flags().IntVar(&config.Concurrency, "concurrency", config.Concurrency, "How many jobs to run at once")
+	cmd.Flags().StringSliceVar(&config.Labels, "label", config.Labels, "A label describing this runner, such as a tool it has installed, in addition to any labels in config.toml")
+	cmd.Flags().BoolVar(&config.CleanEnv, "cleanEnv", config.CleanEnv, "Start jobs with only allowlisted environment variables instead of this runner's whole environment")
+	cmd.Flags().StringSliceVar(&config.EnvAllowlist, "allowEnv", config.EnvAllowlist, "An environment variable jobs may see when --cleanEnv is set, in addition to the usual system ones; a trailing * matches any suffix")
+	cmd.Flags().StringVar(&config.RepoUrl, "repoUrl", config.RepoUrl, "The HTTPS URL of a Git repo to watch, in addition to any [[repos]] in config.toml")
 
 	err := cmd.Execute()
 	if err != nil {
 		panic(err)
 	}
 }
+
+// hasNotifySettings reports whether a runner's config.toml still has the
+// notifiers runners used to send themselves.
+func hasNotifySettings(configBytes []byte) bool {
+	tree, err := toml.LoadBytes(configBytes)
+	if err != nil {
+		return false
+	}
+
+	if tree.Has("notify") || tree.Has("slackToken") {
+		return true
+	}
+	if repos, ok := tree.Get("repos").([]*toml.Tree); ok {
+		for _, repo := range repos {
+			if repo.Has("notify") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// hasForgeSettings reports whether any repo in a runner's config.toml still
+// has the forge settings runners used to report statuses with.
+func hasForgeSettings(configBytes []byte) bool {
+	tree, err := toml.LoadBytes(configBytes)
+	if err != nil {
+		return false
+	}
+
+	if repos, ok := tree.Get("repos").([]*toml.Tree); ok {
+		for _, repo := range repos {
+			if repo.Has("github") || repo.Has("gitea") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -2,42 +2,138 @@ package app
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path"
-	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/fatih/color"
 	"github.com/frc-2175/benkins/shared"
 	"github.com/pelletier/go-toml"
 	"golang.org/x/crypto/ssh/terminal"
-	"gopkg.in/src-d/go-git.v4"
-	"gopkg.in/src-d/go-git.v4/plumbing"
 )
 
 type Config struct {
 	// Deprecated!
 	Script string
 
-	Run       []string
-	Artifacts []string
+	// Run is shorthand for a single step, and is ignored if Steps is
+	// provided.
+	Run   []string
+	Steps []Step
+
+	// Artifacts can be a list of paths or a list of tables, like
+	// [[artifacts]], to set more options. See ParseConfig.
+	Artifacts []Artifact `toml:"-"`
+
+	// Timeout limits the total time taken by all steps. If it is zero, the
+	// runner's default is used.
+	Timeout time.Duration
+
+	// Name identifies the job, for example as the context of commit
+	// statuses. It defaults to "benkins".
+	Name string
+
+	// RunsOn lists the labels a runner must have to run this job.
+	RunsOn []string `toml:"runs-on"`
+}
+
+// Artifact is a file in the workspace that is uploaded to the server when the
+// job finishes.
+type Artifact struct {
+	Path string `toml:"path"`
+
+	// Notify attaches the artifact to notifications too, if it is small
+	// enough.
+	Notify bool `toml:"notify"`
 }
 
-func Main(name, serverUrl, password, slackToken, slackChannelId, repoUrl string) {
+// ParseConfig reads a benkins.toml. Artifacts can be given as plain paths or
+// as tables, which TOML can't express with one type.
+func ParseConfig(configBytes []byte) (Config, error) {
+	var config Config
+
+	tree, err := toml.LoadBytes(configBytes)
+	if err != nil {
+		return config, err
+	}
+
+	// Keys match fields regardless of case, like everywhere else
+	var artifacts interface{}
+	for _, key := range tree.Keys() {
+		if strings.EqualFold(key, "artifacts") {
+			artifacts = tree.Get(key)
+			tree.Delete(key)
+		}
+	}
+
+	err = tree.Unmarshal(&config)
+	if err != nil {
+		return config, err
+	}
+
+	switch artifacts := artifacts.(type) {
+	case nil:
+	case []interface{}:
+		for _, artifact := range artifacts {
+			path, ok := artifact.(string)
+			if !ok {
+				return config, fmt.Errorf("artifacts must be paths or tables, not %v", artifact)
+			}
+			config.Artifacts = append(config.Artifacts, Artifact{Path: path})
+		}
+	case []*toml.Tree:
+		for _, artifactTree := range artifacts {
+			var artifact Artifact
+			if err := artifactTree.Unmarshal(&artifact); err != nil {
+				return config, err
+			}
+			if artifact.Path == "" {
+				return config, fmt.Errorf("every artifact needs a path")
+			}
+			config.Artifacts = append(config.Artifacts, artifact)
+		}
+	default:
+		return config, fmt.Errorf("artifacts must be a list of paths or tables")
+	}
+
+	return config, nil
+}
+
+// RunnerConfig is the runner's own configuration, read from config.toml and
+// command-line flags.
+type RunnerConfig struct {
+	Name           string
+	ServerUrl      string
+	Password       string
+	RepoUrl        string
+	CacheDir       string
+	DefaultTimeout time.Duration
+	MaxTimeout     time.Duration
+	Concurrency    int
+	Labels         []string
+	Repos          []Repo
+
+	// CleanEnv starts jobs with only the variables in EnvAllowlist from
+	// the runner's environment, instead of all of them, so that jobs can't
+	// see the runner's secrets. See jobEnv.
+	CleanEnv     bool
+	EnvAllowlist []string
+}
+
+func Main(config RunnerConfig) {
 	reader := bufio.NewReader(os.Stdin)
 
-	for name == "" {
+	for config.Name == "" {
 		fmt.Print("Enter a name to use to identify this computer: ")
 		tempName, err := reader.ReadString('\n')
 		if err != nil {
@@ -46,12 +142,12 @@ func Main(name, serverUrl, password, slackToken, slackChannelId, repoUrl string)
 		}
 		tempName = strings.TrimSpace(tempName)
 
-		name = tempName
+		config.Name = tempName
 	}
 
-	for serverUrl == "" || password == "" {
-		tempUrl := serverUrl
-		if serverUrl == "" {
+	for config.ServerUrl == "" || config.Password == "" {
+		tempUrl := config.ServerUrl
+		if config.ServerUrl == "" {
 			var err error
 			fmt.Print("Enter the Benkins server URL: ")
 			tempUrl, err = reader.ReadString('\n')
@@ -62,8 +158,8 @@ func Main(name, serverUrl, password, slackToken, slackChannelId, repoUrl string)
 			tempUrl = strings.TrimSpace(tempUrl)
 		}
 
-		tempPassword := password
-		if password == "" {
+		tempPassword := config.Password
+		if config.Password == "" {
 			fmt.Print("Enter the password for the server (press Ctrl-Z instead of Enter on Windows): ")
 			passwordBytes, err := terminal.ReadPassword(int(os.Stdin.Fd()))
 			if err != nil {
@@ -87,44 +183,13 @@ func Main(name, serverUrl, password, slackToken, slackChannelId, repoUrl string)
 			continue
 		}
 
-		serverUrl = tempUrl
-		password = tempPassword
-
-		break
-	}
-
-	var slack *SlackClient
-	for slack == nil {
-		if slackToken == "" {
-			fmt.Print("Enter the Slack OAuth token (press Ctrl-Z instead of Enter on Windows): ")
-			tokenBytes, err := terminal.ReadPassword(int(os.Stdin.Fd()))
-			if err != nil {
-				fmt.Printf("ERROR: %v\n", err)
-				continue
-			}
-			fmt.Println()
-			slackToken = strings.TrimSpace(string(tokenBytes))
-		}
-
-		tempChannelId := slackChannelId
-		if slackChannelId == "" {
-			var err error
-			fmt.Print("Enter the Slack channel ID (NOT the channel name): ")
-			tempChannelId, err = reader.ReadString('\n')
-			if err != nil {
-				fmt.Printf("ERROR: %v\n", err)
-				continue
-			}
-			tempChannelId = strings.TrimSpace(tempChannelId)
-		}
-
-		slack = NewSlackClient(slackToken)
-		slackChannelId = tempChannelId
+		config.ServerUrl = tempUrl
+		config.Password = tempPassword
 
 		break
 	}
 
-	for repoUrl == "" {
+	for len(config.Repos) == 0 {
 		fmt.Print("Enter a repo URL (HTTPS): ")
 		url, err := reader.ReadString('\n')
 		if err != nil {
@@ -132,317 +197,23 @@ func Main(name, serverUrl, password, slackToken, slackChannelId, repoUrl string)
 			continue
 		}
 
-		repoUrl = strings.TrimSpace(url)
+		config.Repos = append(config.Repos, Repo{URL: strings.TrimSpace(url)})
 		break
 	}
-	projectName := ProjectName(repoUrl)
 
-	// heartbeats
+	// Cancel running jobs, and with them their process trees, when the
+	// runner is asked to shut down.
+	shutdown, stop := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		url := BuildUrl(serverUrl, "api")
-		q := url.Query()
-		q.Add("name", name)
-		url.RawQuery = q.Encode()
-
-		for {
-			authedGet(url, password)
-			time.Sleep(1 * time.Minute)
-		}
+		sig := <-signals
+		fmt.Printf("\nReceived %v; stopping running jobs...\n", sig)
+		stop()
 	}()
 
-	ticker := time.NewTicker(time.Minute * 1)
-
-	for {
-		func() {
-			defer func() {
-				if recovered := recover(); recovered != nil {
-					fmt.Fprintf(os.Stderr, "PANIC RECOVERED: %v", recovered)
-				}
-			}()
-
-			// Check for new commits to run on
-			fmt.Printf("\nChecking for new commits...\n")
-			var branchesToRun []*plumbing.Reference
-			func() {
-				repo, _, cleanup := temporaryCheckout(repoUrl, "", NewColorWriter(os.Stdout, color.New(color.FgHiBlack)))
-				defer cleanup()
-
-				err := repo.Fetch(&git.FetchOptions{
-					Progress: os.Stdout,
-				})
-				if err != nil && err != git.NoErrAlreadyUpToDate {
-					panic(err)
-				}
-
-				remote, err := repo.Remote("origin")
-				must(err)
-				remoteRefs, err := remote.List(&git.ListOptions{})
-				must(err)
-				for _, remoteRef := range remoteRefs {
-					refName := remoteRef.Name().String()
-
-					if !strings.HasPrefix(refName, "refs/heads/") {
-						continue
-					}
-
-					branchesToRun = append(branchesToRun, remoteRef)
-				}
-			}()
-
-			for _, branch := range branchesToRun {
-				func() {
-					defer func() {
-						if recovered := recover(); recovered != nil {
-							fmt.Fprintf(os.Stderr, "PANIC RECOVERED: %v", recovered)
-						}
-					}()
-
-					outputBuffer := &bytes.Buffer{}
-
-					stdout := io.MultiWriter(os.Stdout, outputBuffer)
-					stderr := io.MultiWriter(os.Stderr, outputBuffer)
-
-					branchName := branch.Name().Short()
-					hash := branch.Hash().String()
-					color.New(color.Bold).Fprintf(stdout, "\nRunning for branch %v (commit %v)\n", branchName, hash)
-
-					// Check if the server has already run for this commit
-					res, err := authedGet(BuildUrl(serverUrl, "api", projectName.Encoded(), hash), password)
-					if err != nil {
-						fmt.Fprintf(stderr, "WARNING: failed to check if this commit has already run: %v\n", err)
-						fmt.Fprintf(stderr, "Skipping job.\n")
-						return
-					}
-
-					if !((200 <= res.StatusCode && res.StatusCode <= 299) || res.StatusCode == http.StatusNotFound) {
-						fmt.Fprintf(stderr, "WARNING: got unexpected status code when checking if this commit has already run: %v\n", res.StatusCode)
-						dump, _ := httputil.DumpResponse(res, true)
-						fmt.Fprintf(stderr, string(dump)+"\n")
-						return
-					}
-
-					if res.StatusCode == http.StatusOK {
-						fmt.Fprintf(stdout, "This commit has already been run; skipping.\n")
-						return
-					}
-
-					repo, dir, cleanup := temporaryCheckout(repoUrl, hash, nil)
-					defer cleanup()
-
-					commit, err := repo.CommitObject(branch.Hash())
-					if err != nil {
-						fmt.Fprintf(stderr, "ERROR getting commit info: %v\n", err)
-					}
-
-					var config Config
-
-					files, _ := ioutil.ReadDir(dir)
-					didParse := false
-					for _, f := range files {
-						if f.Name() == "benkins.toml" {
-							configBytes, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
-							if err != nil {
-								fmt.Fprintf(stderr, "ERROR reading benkins.toml: %v\n", err)
-								return
-							}
-
-							err = toml.Unmarshal(configBytes, &config)
-							if err != nil {
-								fmt.Fprintf(stderr, "ERROR reading benkins.toml: %v\n", err)
-								return
-							}
-
-							didParse = true
-							break
-						}
-					}
-
-					if !didParse {
-						fmt.Fprintf(stderr, "WARNING: could not find benkins.toml, so not running anything\n")
-						return
-					}
-
-					jobResults := shared.JobResults{
-						BranchName:    branchName,
-						CommitMessage: commit.Message,
-					}
-
-					if len(config.Run) == 0 {
-						fmt.Fprintf(stderr, "WARNING: Run was not provided, falling back to Script\n")
-
-						scriptPath := filepath.Join(dir, config.Script)
-						if _, err := os.Stat(scriptPath); os.IsNotExist(err) {
-							fmt.Fprintf(stderr, "ERROR: could not find script named '%v'\n", config.Script)
-							return
-						}
-
-						config.Run = []string{scriptPath}
-					}
-
-					if config.Run[0] == "" {
-						fmt.Fprintf(stderr, "ERROR: you must provide Run in the benkins.toml\n")
-						return
-					}
-
-					// Run the script
-					func() {
-						ctx, cancel := context.WithTimeout(context.Background(), time.Minute*5)
-						defer cancel()
-
-						cmd := exec.CommandContext(ctx, config.Run[0], config.Run[1:]...)
-						cmd.Env = append(os.Environ(), // TODO: Environment variables what make sense
-							"BENKINS_COMMIT_HASH="+hash,
-						)
-						cmd.Dir = dir
-
-						cmd.Stdout = stdout
-						cmd.Stderr = NewColorWriter(stderr, color.New(color.Bold, color.FgRed))
-
-						must(cmd.Start())
-						err := cmd.Wait()
-						if err != nil {
-							if _, isExitError := err.(*exec.ExitError); !isExitError {
-								panic(err)
-							}
-						}
-
-						if cmd.ProcessState.Success() {
-							color.New(color.FgGreen, color.Bold).Fprintf(stdout, "Script executed successfully.\n")
-						} else {
-							color.New(color.FgRed, color.Bold).Fprintf(stderr, "Script failed with exit code %v.\n", cmd.ProcessState.ExitCode())
-						}
-
-						jobResults.Success = cmd.ProcessState.Success()
-					}()
-
-					// Upload the artifacts
-					func() {
-						requestBody := &bytes.Buffer{}
-						writer := multipart.NewWriter(requestBody)
-
-						err := WriteMultipartFile(writer, shared.ExecutionLogFilename, outputBuffer)
-						if err != nil {
-							fmt.Printf("WARNING: Failed to add execution log as artifact")
-						}
-
-						err = WriteMultipartFile(writer, shared.ResultsFilename, bytes.NewBufferString(jobResults.ToTOML()))
-						if err != nil {
-							fmt.Printf("WARNING: Failed to add job results as an artifact")
-						}
-
-						for _, artifactName := range config.Artifacts {
-							func() {
-								file, err := os.Open(filepath.Join(dir, artifactName))
-								if os.IsNotExist(err) {
-									fmt.Fprintf(stderr, "WARNING: Failed to read artifact '%v'\n", artifactName)
-									return
-								}
-								defer file.Close()
-
-								err = WriteMultipartFile(writer, artifactName, file)
-								if err != nil {
-									fmt.Fprintf(stderr, "ERROR adding artifact to request: %v\n", err)
-									return
-								}
-							}()
-						}
-
-						err = writer.Close()
-						if err != nil {
-							fmt.Fprintf(stderr, "ERROR: Failed to close multipart write for artifacts")
-							return
-						}
-
-						res, err := authedPost(
-							BuildUrl(serverUrl, "api", projectName.Encoded(), hash, "artifacts"),
-							writer.FormDataContentType(),
-							password,
-							requestBody,
-						)
-						if err != nil {
-							fmt.Fprintf(stderr, "ERROR uploading artifacts to server: %v\n", err)
-						}
-						if res.StatusCode < 200 || 299 < res.StatusCode {
-							fmt.Fprintf(stderr, "ERROR: did not receive success from server when uploading artifacts: \n")
-							dump, _ := httputil.DumpResponse(res, true)
-							fmt.Fprintf(stderr, string(dump)+"\n")
-						}
-					}()
-
-					// Notify us on Slack
-					if slackChannelId != "test" {
-						notificationText := ""
-
-						if notificationBytes, err := ioutil.ReadFile(filepath.Join(dir, shared.NotificationFilename)); err == nil {
-							notificationText = string(notificationBytes)
-						} else {
-							if os.IsNotExist(err) {
-								fmt.Println("No custom notification text.")
-							} else {
-								fmt.Fprintf(stderr, "WARNING: error while reading custom notification text")
-							}
-						}
-
-						successEmoji := ":white_check_mark:"
-						successString := "Success!"
-						if !jobResults.Success {
-							successEmoji = ":x:"
-							successString = "Failure"
-						}
-
-						_, err := slack.SlackPostMessage(SlackMessageRequest{
-							Channel: slackChannelId,
-							Text:    fmt.Sprintf("%s Branch %s (Commit %s) %s", successEmoji, branchName, hash[0:7], successString),
-							Blocks: []*SlackBlock{
-								TextBlock("*%s Branch %s (Commit %s) %s*", successEmoji, branchName, hash[0:7], successString),
-								TextBlock("Message: %s", commit.Message),
-								TextBlock(notificationText),
-								TextBlock("<%s|View the full results>", BuildUrl(serverUrl, "p", projectName.Encoded(), hash)),
-							},
-						})
-						if err == nil {
-							fmt.Fprintf(stdout, "Successfully posted message to Slack.\n")
-						} else {
-							fmt.Fprintf(stderr, "ERROR posting message to Slack: %v\n", err)
-						}
-					}
-
-					// TODO: Update CI status on GitHub
-
-					fmt.Fprintf(stdout, "Done.\n")
-				}()
-			}
-		}()
-
-		<-ticker.C
-	}
-}
-
-func temporaryCheckout(url string, hash string, progress io.Writer) (repo *git.Repository, dir string, cleanup func()) {
-	tmpdir, _ := ioutil.TempDir("", "")
-
-	if progress == nil {
-		progress = os.Stdout
-	}
-
-	r, err := git.PlainClone(tmpdir, false, &git.CloneOptions{
-		URL:      url,
-		Progress: progress,
-	})
-	must(err)
-
-	wt, err := r.Worktree()
-	must(err)
-
-	opts := &git.CheckoutOptions{}
-	if hash != "" {
-		opts.Hash = plumbing.NewHash(hash)
-	}
-	must(wt.Checkout(opts))
-
-	return r, tmpdir, func() {
-		must(os.RemoveAll(tmpdir))
-	}
+	runner := NewRunner(config)
+	runner.Run(shutdown)
 }
 
 func must(errs ...error) {
@@ -471,6 +242,43 @@ func (w ColorWriter) Write(p []byte) (n int, err error) {
 	return w.Color.Fprint(w.W, string(p))
 }
 
+// PrefixWriter writes Prefix at the start of every line.
+type PrefixWriter struct {
+	W      io.Writer
+	Prefix string
+
+	midLine bool
+}
+
+var _ io.Writer = &PrefixWriter{}
+
+func NewPrefixWriter(w io.Writer, prefix string) *PrefixWriter {
+	return &PrefixWriter{
+		W:      w,
+		Prefix: prefix,
+	}
+}
+
+func (w *PrefixWriter) Write(p []byte) (n int, err error) {
+	var out []byte
+	for _, b := range p {
+		if !w.midLine {
+			out = append(out, w.Prefix...)
+			w.midLine = true
+		}
+		out = append(out, b)
+		if b == '\n' {
+			w.midLine = false
+		}
+	}
+
+	if _, err := w.W.Write(out); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
 func WriteMultipartFile(w *multipart.Writer, name string, src io.Reader) error {
 	fileWriter, err := w.CreateFormFile("files", name)
 	if err != nil {
@@ -486,8 +294,9 @@ func ProjectName(repoUrl string) shared.ProjectName {
 	var result string
 
 	u, _ := url.Parse(repoUrl)
+	// A .git suffix is kept, since the server already stores results under
+	// names that have it
 	result = strings.Trim(u.EscapedPath(), "/")
-	strings.TrimSuffix(result, ".git")
 
 	return shared.NewProjectNameFromPlain(result)
 }
@@ -505,7 +314,9 @@ func BuildUrl(baseUrl string, components ...string) *url.URL {
 	return u
 }
 
-var serverClient = &http.Client{}
+// The timeout is long enough for big artifact uploads, but keeps a server
+// that stopped responding from hanging the runner forever.
+var serverClient = &http.Client{Timeout: 5 * time.Minute}
 
 func authedGet(url *url.URL, password string) (*http.Response, error) {
 	req, err := http.NewRequest("GET", url.String(), nil)
@@ -518,6 +329,39 @@ func authedGet(url *url.URL, password string) (*http.Response, error) {
 	return serverClient.Do(req)
 }
 
+func authedPostForm(url *url.URL, password string, form url.Values) (*http.Response, error) {
+	return authedPost(url, "application/x-www-form-urlencoded", password, strings.NewReader(form.Encode()))
+}
+
+// leasedGet is like authedGet, but also shows the server that we hold the
+// lease on the job the request is for.
+func leasedGet(url *url.URL, password, lease string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", password)
+	req.Header.Set(shared.LeaseHeader, lease)
+
+	return serverClient.Do(req)
+}
+
+// leasedPost is like authedPost, but also shows the server that we hold the
+// lease on the job the request is for.
+func leasedPost(url *url.URL, contentType, password, lease string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest("POST", url.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", password)
+	req.Header.Set(shared.LeaseHeader, lease)
+
+	return serverClient.Do(req)
+}
+
 func authedPost(url *url.URL, contentType, password string, body io.Reader) (*http.Response, error) {
 	req, err := http.NewRequest("POST", url.String(), body)
 	if err != nil {
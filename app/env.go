@@ -0,0 +1,106 @@
+package app
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// The variables a clean environment keeps, which programs need to work at
+// all. A trailing * matches any suffix.
+var defaultEnvAllowlist = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TERM", "LANG", "LC_*", "TZ",
+	"TMPDIR", "TEMP", "TMP",
+
+	// Windows
+	"SYSTEMROOT", "SYSTEMDRIVE", "WINDIR", "COMSPEC", "PATHEXT", "USERNAME",
+	"USERPROFILE", "HOMEDRIVE", "HOMEPATH", "APPDATA", "LOCALAPPDATA",
+	"PROGRAMDATA", "PROGRAMFILES", "PROGRAMFILES(X86)", "PROGRAMW6432",
+	"COMMONPROGRAMFILES", "COMMONPROGRAMFILES(X86)", "OS",
+	"PROCESSOR_ARCHITECTURE", "NUMBER_OF_PROCESSORS",
+}
+
+// jobEnv is the environment a job's steps run in. It is the runner's own
+// environment, or just the allowlisted parts of it if CleanEnv is set, plus
+// these variables describing the job:
+//
+//	BENKINS, CI            always "true"
+//	BENKINS_PROJECT        the project's name, like "frc-2175/robot.git"
+//	BENKINS_BRANCH         the branch being built
+//	BENKINS_COMMIT_HASH    the full hash of the commit being built
+//	BENKINS_RUN_ID         a unique ID for this run of the job
+//	BENKINS_ATTEMPT        1, or more if the job was retried after its runner was lost
+//	BENKINS_RERUN          "true" if someone asked for the commit to be built again
+//	BENKINS_TRIGGER        why the job ran: "push", "rerun", or "retry" (see README.md)
+//	BENKINS_RUNNER         the name of the runner running the job
+//	BENKINS_RESULTS_URL    the commit's results page on the server
+//	BENKINS_WORKSPACE      the directory the commit is checked out in
+//
+// Any of these in the runner's environment are replaced. Steps can set more
+// variables in benkins.toml.
+func (r *Runner) jobEnv(job Job, dir string) []string {
+	projectName := job.Repo.ProjectName()
+
+	trigger := "push"
+	if job.Attempt > 1 {
+		trigger = "retry"
+	} else if job.Rerun {
+		trigger = "rerun"
+	}
+
+	var env []string
+	for _, variable := range os.Environ() {
+		name := strings.SplitN(variable, "=", 2)[0]
+		if strings.HasPrefix(strings.ToUpper(name), "BENKINS_") {
+			continue
+		}
+		if r.CleanEnv && !envAllowed(name, r.EnvAllowlist) {
+			continue
+		}
+
+		env = append(env, variable)
+	}
+
+	return append(env,
+		"BENKINS=true",
+		"CI=true",
+		"BENKINS_PROJECT="+projectName.Decoded(),
+		"BENKINS_BRANCH="+job.Branch,
+		"BENKINS_COMMIT_HASH="+job.Hash,
+		"BENKINS_RUN_ID="+job.RunId,
+		"BENKINS_ATTEMPT="+strconv.Itoa(job.Attempt),
+		"BENKINS_RERUN="+strconv.FormatBool(job.Rerun),
+		"BENKINS_TRIGGER="+trigger,
+		"BENKINS_RUNNER="+r.Name,
+		"BENKINS_RESULTS_URL="+BuildUrl(r.ServerUrl, "p", projectName.Encoded(), job.Hash).String(),
+		"BENKINS_WORKSPACE="+dir,
+	)
+}
+
+// envAllowed reports whether a clean environment keeps the variable called
+// name, because it is in the default allowlist or the runner's.
+func envAllowed(name string, allowlist []string) bool {
+	// Windows doesn't care about the case of variable names
+	if runtime.GOOS == "windows" {
+		name = strings.ToUpper(name)
+	}
+
+	for _, patterns := range [][]string{defaultEnvAllowlist, allowlist} {
+		for _, pattern := range patterns {
+			if runtime.GOOS == "windows" {
+				pattern = strings.ToUpper(pattern)
+			}
+
+			if strings.HasSuffix(pattern, "*") {
+				if strings.HasPrefix(name, strings.TrimSuffix(pattern, "*")) {
+					return true
+				}
+			} else if name == pattern {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,453 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/frc-2175/benkins/shared"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// Job is a single run of a repo's benkins.toml against one commit.
+type Job struct {
+	Repo   Repo
+	Mirror *Mirror
+	Branch string
+	Hash   string
+
+	// The lease the server gave us for this job, which must accompany
+	// everything we upload for it
+	Lease   string
+	Rerun   bool
+	Attempt int
+	RunId   string
+}
+
+// RunJob checks out the job's commit into its own workspace, runs its steps,
+// and reports the results. Each job has its own workspace and log, so several
+// jobs can run at once.
+func (r *Runner) RunJob(ctx context.Context, job Job) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			fmt.Fprintf(os.Stderr, "PANIC RECOVERED: %v", recovered)
+		}
+	}()
+
+	outputBuffer := &LogBuffer{}
+
+	var consoleOut, consoleErr io.Writer = os.Stdout, os.Stderr
+	if r.Concurrency > 1 {
+		// Keep the console readable when several jobs are writing to it
+		prefix := fmt.Sprintf("[%s %s] ", job.Branch, job.Hash[0:7])
+		consoleOut = NewPrefixWriter(os.Stdout, prefix)
+		consoleErr = NewPrefixWriter(os.Stderr, prefix)
+	}
+
+	stdout := io.MultiWriter(consoleOut, outputBuffer)
+	stderr := io.MultiWriter(consoleErr, outputBuffer)
+
+	projectName := job.Repo.ProjectName()
+	branchName := job.Branch
+	hash := job.Hash
+	color.New(color.Bold).Fprintf(stdout, "\nRunning for branch %v (commit %v)\n", branchName, hash)
+
+	jobResults := shared.JobResults{
+		BranchName: branchName,
+	}
+
+	if !job.Mirror.Has(hash) {
+		err := job.Mirror.Fetch(NewColorWriter(consoleOut, color.New(color.FgHiBlack)))
+		if err != nil {
+			fmt.Fprintf(stderr, "ERROR fetching from %v: %v\n", job.Repo.URL, err)
+			r.reportError(job, jobResults, outputBuffer, "failed to fetch commit")
+			return
+		}
+	}
+
+	repo, dir, cleanup, err := job.Mirror.Checkout(hash)
+	if err != nil {
+		fmt.Fprintf(stderr, "ERROR creating workspace: %v\n", err)
+		r.reportError(job, jobResults, outputBuffer, "failed to create workspace")
+		return
+	}
+	defer cleanup()
+
+	commit, err := repo.CommitObject(plumbing.NewHash(hash))
+	if err != nil {
+		fmt.Fprintf(stderr, "ERROR getting commit info: %v\n", err)
+		r.reportError(job, jobResults, outputBuffer, "failed to get commit info")
+		return
+	}
+	jobResults.CommitMessage = commit.Message
+	jobResults.Author = commit.Author.Name
+	jobResults.AuthorEmail = commit.Author.Email
+
+	var config Config
+
+	files, _ := ioutil.ReadDir(dir)
+	didParse := false
+	for _, f := range files {
+		if f.Name() == "benkins.toml" {
+			configBytes, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+			if err != nil {
+				fmt.Fprintf(stderr, "ERROR reading benkins.toml: %v\n", err)
+				r.reportError(job, jobResults, outputBuffer, "invalid benkins.toml")
+				return
+			}
+
+			config, err = ParseConfig(configBytes)
+			if err != nil {
+				fmt.Fprintf(stderr, "ERROR reading benkins.toml: %v\n", err)
+				r.reportError(job, jobResults, outputBuffer, "invalid benkins.toml")
+				return
+			}
+
+			didParse = true
+			break
+		}
+	}
+
+	if !didParse {
+		fmt.Fprintf(stderr, "WARNING: could not find benkins.toml, so not running anything\n")
+		r.reportError(job, jobResults, outputBuffer, "no benkins.toml")
+		return
+	}
+
+	jobResults.Name = config.Name
+
+	if !shared.LabelsMatch(config.RunsOn, r.Labels) {
+		fmt.Fprintf(stdout, "This job needs a runner labeled %s, so handing it back to the server.\n", strings.Join(config.RunsOn, ", "))
+		if err := r.release(job, config.RunsOn); err != nil {
+			fmt.Fprintf(stderr, "WARNING: failed to hand the job back to the server: %v\n", err)
+		}
+		return
+	}
+
+	if len(config.Steps) == 0 {
+		if len(config.Run) == 0 {
+			fmt.Fprintf(stderr, "WARNING: Run was not provided, falling back to Script\n")
+
+			scriptPath := filepath.Join(dir, config.Script)
+			if _, err := os.Stat(scriptPath); os.IsNotExist(err) {
+				fmt.Fprintf(stderr, "ERROR: could not find script named '%v'\n", config.Script)
+				r.reportError(job, jobResults, outputBuffer, "script not found")
+				return
+			}
+
+			config.Run = []string{scriptPath}
+		}
+
+		if config.Run[0] == "" {
+			fmt.Fprintf(stderr, "ERROR: you must provide Run or Steps in the benkins.toml\n")
+			r.reportError(job, jobResults, outputBuffer, "invalid benkins.toml")
+			return
+		}
+
+		config.Steps = []Step{{Name: "run", Command: config.Run}}
+	}
+
+	secrets, err := r.fetchSecrets(job, config.Steps)
+	if err != nil {
+		fmt.Fprintf(stderr, "ERROR: failed to fetch secrets: %v\n", err)
+		r.reportError(job, jobResults, outputBuffer, "failed to fetch secrets")
+		return
+	}
+
+	jobResults.TotalSteps = len(config.Steps)
+
+	// Let the server know the job has started, then stream the log to it
+	// as the job runs.
+	runningResults := jobResults
+	runningResults.Status = shared.StatusRunning
+	if err := r.uploadResults(job, runningResults); err != nil {
+		fmt.Fprintf(stderr, "WARNING: failed to report that the job is running: %v\n", err)
+	}
+
+	logStreamer := NewLogStreamer(outputBuffer, BuildUrl(r.ServerUrl, "api", projectName.Encoded(), hash, "log"), r.Password, job.Lease)
+	logStreamer.Start()
+
+	// Stop working on the job if the server gives it to another runner
+	jobCtx, cancelJob := context.WithCancel(ctx)
+	defer cancelJob()
+	go func() {
+		select {
+		case <-logStreamer.LeaseLost():
+			fmt.Fprintf(consoleErr, "The server has given this job to another runner, so stopping.\n")
+			cancelJob()
+		case <-jobCtx.Done():
+		}
+	}()
+
+	timeout := r.DefaultTimeout
+	if config.Timeout > 0 {
+		timeout = config.Timeout
+	}
+	if r.MaxTimeout > 0 && timeout > r.MaxTimeout {
+		fmt.Fprintf(stderr, "WARNING: timeout of %v is longer than this runner's maximum, so using %v instead\n", timeout, r.MaxTimeout)
+		timeout = r.MaxTimeout
+	}
+
+	// Run the steps
+	func() {
+		ctx, cancel := context.WithTimeout(jobCtx, timeout)
+		defer cancel()
+
+		env := r.jobEnv(job, dir)
+
+		// Show each step's results as soon as it finishes
+		progress := func(steps []shared.StepResult) {
+			runningResults.Steps = steps
+			if err := r.uploadResults(job, runningResults); err != nil {
+				fmt.Fprintf(consoleErr, "WARNING: failed to report the job's progress: %v\n", err)
+			}
+		}
+
+		start := time.Now()
+		jobResults.Steps = RunSteps(ctx, config.Steps, dir, env, secrets, stdout, stderr, progress)
+		jobResults.Duration = time.Since(start).Round(time.Millisecond)
+
+		jobResults.Status = shared.StatusSuccess
+		for _, step := range jobResults.Steps {
+			if step.Status == shared.StatusTimedOut {
+				jobResults.Status = shared.StatusTimedOut
+				break
+			}
+			if step.Status != shared.StatusSuccess && !step.ContinueOnError {
+				jobResults.Status = shared.StatusFailure
+			}
+		}
+		jobResults.Success = jobResults.Status == shared.StatusSuccess
+
+		switch jobResults.Status {
+		case shared.StatusSuccess:
+			color.New(color.FgGreen, color.Bold).Fprintf(stdout, "\nJob succeeded in %v.\n", jobResults.Duration)
+		case shared.StatusTimedOut:
+			color.New(color.FgRed, color.Bold).Fprintf(stderr, "\nJob timed out after %v.\n", jobResults.Duration)
+		default:
+			color.New(color.FgRed, color.Bold).Fprintf(stderr, "\nJob failed after %v.\n", jobResults.Duration)
+		}
+	}()
+
+	if ctx.Err() != nil {
+		fmt.Fprintf(stderr, "The runner is shutting down, so handing this job back to the server.\n")
+		logStreamer.Stop()
+		if err := r.release(job, config.RunsOn); err != nil {
+			fmt.Fprintf(consoleErr, "WARNING: failed to hand the job back to the server: %v\n", err)
+		}
+		return
+	}
+	if jobCtx.Err() != nil {
+		logStreamer.Stop()
+		return
+	}
+
+	if err := logStreamer.Stop(); err != nil {
+		fmt.Fprintf(stderr, "WARNING: failed to stream the end of the log: %v\n", err)
+	}
+
+	// The server sends notifications once it has the final results
+	if notificationBytes, err := ioutil.ReadFile(filepath.Join(dir, shared.NotificationFilename)); err == nil {
+		jobResults.Notification = string(notificationBytes)
+	} else if !os.IsNotExist(err) {
+		fmt.Fprintf(stderr, "WARNING: error while reading custom notification text")
+	}
+	for _, artifact := range config.Artifacts {
+		if artifact.Notify {
+			jobResults.NotifyArtifacts = append(jobResults.NotifyArtifacts, artifact.Path)
+		}
+	}
+
+	// Upload the artifacts
+	func() {
+		requestBody := &bytes.Buffer{}
+		writer := multipart.NewWriter(requestBody)
+
+		err := WriteMultipartFile(writer, shared.ExecutionLogFilename, bytes.NewReader(outputBuffer.Bytes()))
+		if err != nil {
+			fmt.Printf("WARNING: Failed to add execution log as artifact")
+		}
+
+		err = WriteMultipartFile(writer, shared.ResultsFilename, bytes.NewBufferString(jobResults.ToTOML()))
+		if err != nil {
+			fmt.Printf("WARNING: Failed to add job results as an artifact")
+		}
+
+		for _, artifact := range config.Artifacts {
+			artifactName := artifact.Path
+			func() {
+				file, err := os.Open(filepath.Join(dir, artifactName))
+				if os.IsNotExist(err) {
+					fmt.Fprintf(stderr, "WARNING: Failed to read artifact '%v'\n", artifactName)
+					return
+				}
+				defer file.Close()
+
+				err = WriteMultipartFile(writer, artifactName, file)
+				if err != nil {
+					fmt.Fprintf(stderr, "ERROR adding artifact to request: %v\n", err)
+					return
+				}
+			}()
+		}
+
+		err = writer.Close()
+		if err != nil {
+			fmt.Fprintf(stderr, "ERROR: Failed to close multipart write for artifacts")
+			return
+		}
+
+		err = r.postArtifacts(job, writer.FormDataContentType(), requestBody)
+		if err != nil {
+			fmt.Fprintf(stderr, "ERROR uploading artifacts to server: %v\n", err)
+		}
+	}()
+
+	fmt.Fprintf(stdout, "Done.\n")
+}
+
+// uploadResults sends just the job's results to the server, so it can show
+// the job's progress before the artifacts are ready.
+func (r *Runner) uploadResults(job Job, results shared.JobResults) error {
+	requestBody := &bytes.Buffer{}
+	writer := multipart.NewWriter(requestBody)
+
+	err := WriteMultipartFile(writer, shared.ResultsFilename, bytes.NewBufferString(results.ToTOML()))
+	if err != nil {
+		return err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+
+	return r.postArtifacts(job, writer.FormDataContentType(), requestBody)
+}
+
+// fetchSecrets gets the secrets the steps use from the server. Only those
+// are fetched, so that a job can't read secrets it doesn't mention.
+func (r *Runner) fetchSecrets(job Job, steps []Step) (map[string]string, error) {
+	query := url.Values{}
+	seen := map[string]bool{}
+	for _, step := range steps {
+		for _, name := range step.Secrets {
+			if !seen[name] {
+				query.Add("name", name)
+				seen[name] = true
+			}
+		}
+	}
+	if len(seen) == 0 {
+		return nil, nil
+	}
+
+	u := BuildUrl(r.ServerUrl, "api", job.Repo.ProjectName().Encoded(), job.Hash, "secrets")
+	u.RawQuery = query.Encode()
+
+	res, err := leasedGet(u, r.Password, job.Lease)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(res.Body)
+		return nil, fmt.Errorf("server responded with %s: %s", res.Status, message)
+	}
+
+	secrets := map[string]string{}
+	if err := json.NewDecoder(res.Body).Decode(&secrets); err != nil {
+		return nil, err
+	}
+
+	return secrets, nil
+}
+
+// reportError tells the server that the job could not be run, so that it
+// doesn't wait on the job until the lease runs out.
+func (r *Runner) reportError(job Job, results shared.JobResults, log *LogBuffer, message string) {
+	results.Success = false
+	results.Status = shared.StatusErrored
+	results.Error = message
+
+	requestBody := &bytes.Buffer{}
+	writer := multipart.NewWriter(requestBody)
+
+	err := WriteMultipartFile(writer, shared.ExecutionLogFilename, bytes.NewReader(log.Bytes()))
+	if err == nil {
+		err = WriteMultipartFile(writer, shared.ResultsFilename, bytes.NewBufferString(results.ToTOML()))
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if err == nil {
+		err = r.postArtifacts(job, writer.FormDataContentType(), requestBody)
+	}
+	if err != nil {
+		fmt.Printf("WARNING: failed to report that the job errored: %v\n", err)
+	}
+}
+
+// errLeaseLost means the server has given the job to another runner, so
+// nothing more should be done for it.
+var errLeaseLost = errors.New("the server has given this job to another runner")
+
+func (r *Runner) postArtifacts(job Job, contentType string, body io.Reader) error {
+	res, err := leasedPost(
+		BuildUrl(r.ServerUrl, "api", job.Repo.ProjectName().Encoded(), job.Hash, "artifacts"),
+		contentType,
+		r.Password,
+		job.Lease,
+		body,
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusGone {
+		return errLeaseLost
+	}
+	if res.StatusCode < 200 || 299 < res.StatusCode {
+		dump, _ := httputil.DumpResponse(res, true)
+		return fmt.Errorf("did not receive success from server: \n%s", dump)
+	}
+
+	return nil
+}
+
+// LogBuffer collects a job's output. It is safe to write to from several
+// goroutines, since a step's stdout and stderr are copied concurrently.
+type LogBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *LogBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.buf.Write(p)
+}
+
+// Bytes returns a copy of everything written so far.
+func (b *LogBuffer) Bytes() []byte {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return append([]byte(nil), b.buf.Bytes()...)
+}
@@ -0,0 +1,156 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logStreamInterval   = 1 * time.Second
+	logStreamMaxBackoff = 30 * time.Second
+	logStreamChunkSize  = 1 << 20
+)
+
+// LogStreamer sends a job's log to the server in chunks while the job runs,
+// so the server always has the log up to the latest chunk. Chunks that fail
+// to send are retried with backoff, since the whole log stays in the
+// LogBuffer until the job is done.
+type LogStreamer struct {
+	log      *LogBuffer
+	url      *url.URL
+	password string
+	lease    string
+
+	// How much of the log the server has confirmed
+	offset int
+
+	stop chan struct{}
+	done chan struct{}
+
+	leaseLost     chan struct{}
+	leaseLostOnce sync.Once
+}
+
+func NewLogStreamer(log *LogBuffer, url *url.URL, password, lease string) *LogStreamer {
+	return &LogStreamer{
+		log:      log,
+		url:      url,
+		password: password,
+		lease:    lease,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+
+		leaseLost: make(chan struct{}),
+	}
+}
+
+// Start begins sending the log in the background. Anything already in the
+// log is sent with the first chunk.
+func (s *LogStreamer) Start() {
+	go func() {
+		defer close(s.done)
+
+		backoff := logStreamInterval
+		timer := time.NewTimer(backoff)
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-timer.C:
+			case <-s.stop:
+				return
+			}
+
+			if err := s.flush(); err == errLeaseLost {
+				return
+			} else if err != nil {
+				backoff *= 2
+				if backoff > logStreamMaxBackoff {
+					backoff = logStreamMaxBackoff
+				}
+				fmt.Printf("WARNING: failed to stream log to server (retrying in %v): %v\n", backoff, err)
+			} else {
+				backoff = logStreamInterval
+			}
+			timer.Reset(backoff)
+		}
+	}()
+}
+
+// Stop stops streaming and makes a few last attempts to send the rest of the
+// log.
+func (s *LogStreamer) Stop() error {
+	close(s.stop)
+	<-s.done
+
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+
+		if err = s.flush(); err == nil || err == errLeaseLost {
+			return err
+		}
+	}
+
+	return err
+}
+
+// LeaseLost is closed if the server turns the log away because it has given
+// the job to another runner.
+func (s *LogStreamer) LeaseLost() <-chan struct{} {
+	return s.leaseLost
+}
+
+// flush sends everything in the log that the server doesn't have yet.
+func (s *LogStreamer) flush() error {
+	for {
+		log := s.log.Bytes()
+		if s.offset >= len(log) {
+			return nil
+		}
+
+		chunk := log[s.offset:]
+		if len(chunk) > logStreamChunkSize {
+			chunk = chunk[:logStreamChunkSize]
+		}
+
+		u := *s.url
+		q := u.Query()
+		q.Set("offset", strconv.Itoa(s.offset))
+		u.RawQuery = q.Encode()
+
+		res, err := leasedPost(&u, "text/plain; charset=utf-8", s.password, s.lease, bytes.NewReader(chunk))
+		if err != nil {
+			return err
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		// The server replies with the size of its copy of the log, which
+		// tells us where to continue from.
+		size, sizeErr := strconv.Atoi(strings.TrimSpace(string(body)))
+
+		switch {
+		case res.StatusCode == http.StatusOK && sizeErr == nil:
+			s.offset = size
+		case res.StatusCode == http.StatusConflict && sizeErr == nil && size <= len(log):
+			s.offset = size
+		case res.StatusCode == http.StatusGone:
+			s.leaseLostOnce.Do(func() {
+				close(s.leaseLost)
+			})
+			return errLeaseLost
+		default:
+			return fmt.Errorf("got status code %v from server: %s", res.StatusCode, body)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package app
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/client"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/server"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+)
+
+func init() {
+	// Serve file:// URLs in-process so that workspaces can be cloned from a
+	// local mirror without needing a git binary on the runner.
+	client.InstallProtocol("file", server.DefaultServer)
+}
+
+// Mirror is a bare copy of a remote repo kept in the runner's cache
+// directory. It is kept up to date with incremental fetches, and job
+// workspaces are cloned from it locally instead of over the network.
+type Mirror struct {
+	URL string
+	Dir string
+
+	// Guards the mirror, which can't be fetched into and read from at once.
+	// repo caches what it reads without locking, so even reading it takes
+	// the write lock. Workspaces read the mirror through their own handles,
+	// so any number of them can share the read lock.
+	mutex sync.RWMutex
+	repo  *git.Repository
+}
+
+// OpenMirror opens the mirror of url in cacheDir, creating it if it does not
+// exist yet. A new mirror is empty until the first call to Fetch.
+func OpenMirror(cacheDir, url string) (*Mirror, error) {
+	dir := filepath.Join(cacheDir, fmt.Sprintf("%x.git", sha1.Sum([]byte(url))))
+
+	repo, err := git.PlainOpen(dir)
+	if err == git.ErrRepositoryNotExists {
+		repo, err = git.PlainInit(dir, true)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = repo.CreateRemote(&config.RemoteConfig{
+			Name:  git.DefaultRemoteName,
+			URLs:  []string{url},
+			Fetch: []config.RefSpec{"+refs/heads/*:refs/heads/*"},
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Mirror{
+		URL:  url,
+		Dir:  dir,
+		repo: repo,
+	}, nil
+}
+
+// Fetch brings the mirror's branches up to date with the remote, downloading
+// only the objects the mirror does not already have.
+func (m *Mirror) Fetch(progress io.Writer) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	err := m.repo.Fetch(&git.FetchOptions{
+		RemoteName: git.DefaultRemoteName,
+		Progress:   progress,
+		Tags:       git.NoTags,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return err
+	}
+
+	return nil
+}
+
+// ListRemoteBranches asks the remote at url for its branch heads without
+// cloning or fetching anything.
+func ListRemoteBranches(url string) ([]*plumbing.Reference, error) {
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{url},
+	})
+
+	refs, err := remote.List(&git.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*plumbing.Reference
+	for _, ref := range refs {
+		if ref.Name().IsBranch() {
+			result = append(result, ref)
+		}
+	}
+
+	return result, nil
+}
+
+// Has reports whether the mirror already has the commit with the given hash.
+func (m *Mirror) Has(hash string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	_, err := m.repo.CommitObject(plumbing.NewHash(hash))
+	return err == nil
+}
+
+// Checkout creates a fresh workspace in a temporary directory by fetching
+// from the mirror locally and checking out hash. The returned cleanup function
+// deletes the workspace. The mirror is only locked while the workspace
+// fetches from it, so jobs can check out commits of the same repo at once.
+func (m *Mirror) Checkout(hash string) (repo *git.Repository, dir string, cleanup func(), err error) {
+	tmpdir, err := ioutil.TempDir("", "benkins-")
+	if err != nil {
+		return nil, "", nil, err
+	}
+	cleanup = func() {
+		must(os.RemoveAll(tmpdir))
+	}
+
+	r, err := git.PlainInit(tmpdir, false)
+	if err != nil {
+		cleanup()
+		return nil, "", nil, err
+	}
+
+	// The mirror's HEAD may not point at a real branch, so rather than
+	// cloning, fetch its branches into a fresh repo and check out by hash.
+	_, err = r.CreateRemote(&config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{m.Dir},
+	})
+	if err != nil {
+		cleanup()
+		return nil, "", nil, err
+	}
+
+	m.mutex.RLock()
+	err = r.Fetch(&git.FetchOptions{
+		RemoteName: git.DefaultRemoteName,
+		Tags:       git.NoTags,
+	})
+	m.mutex.RUnlock()
+	if err != nil {
+		cleanup()
+		return nil, "", nil, err
+	}
+
+	wt, err := r.Worktree()
+	if err != nil {
+		cleanup()
+		return nil, "", nil, err
+	}
+
+	err = wt.Checkout(&git.CheckoutOptions{
+		Hash: plumbing.NewHash(hash),
+	})
+	if err != nil {
+		cleanup()
+		return nil, "", nil, err
+	}
+
+	return r, tmpdir, cleanup, nil
+}
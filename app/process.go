@@ -0,0 +1,90 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// How long to wait for leftover processes to let go of the output pipes
+// before giving up on the rest of their output.
+const outputDrainTimeout = 5 * time.Second
+
+// RunProcess starts cmd in its own process group and waits for it to exit,
+// copying its output to stdout and stderr. If ctx is done first, the whole
+// process tree is killed. Any processes the command leaves behind are
+// reported to stderr and killed as well, so that nothing outlives the step.
+func RunProcess(ctx context.Context, cmd *exec.Cmd, stdout, stderr io.Writer) error {
+	// Give the command real pipes instead of letting exec copy the output.
+	// Otherwise Wait would block until every leftover process holding the
+	// pipes exited, which is exactly what we're trying to clean up.
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	stderrR, stderrW, err := os.Pipe()
+	if err != nil {
+		stdoutR.Close()
+		stdoutW.Close()
+		return err
+	}
+
+	cmd.Stdout = stdoutW
+	cmd.Stderr = stderrW
+	setProcessGroup(cmd)
+
+	err = cmd.Start()
+	stdoutW.Close()
+	stderrW.Close()
+	if err != nil {
+		stdoutR.Close()
+		stderrR.Close()
+		return err
+	}
+
+	var copying sync.WaitGroup
+	copying.Add(2)
+	go func() {
+		io.Copy(stdout, stdoutR)
+		copying.Done()
+	}()
+	go func() {
+		io.Copy(stderr, stderrR)
+		copying.Done()
+	}()
+
+	waitResult := make(chan error, 1)
+	go func() {
+		waitResult <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-waitResult:
+	case <-ctx.Done():
+		fmt.Fprintf(stderr, "Stopping process tree: %v\n", ctx.Err())
+		killProcessGroup(cmd, stderr)
+		err = <-waitResult
+	}
+
+	killProcessGroup(cmd, stderr)
+
+	copied := make(chan struct{})
+	go func() {
+		copying.Wait()
+		close(copied)
+	}()
+
+	select {
+	case <-copied:
+	case <-time.After(outputDrainTimeout):
+		fmt.Fprintf(stderr, "WARNING: some process outside the process group is still holding the output open; ignoring the rest of its output\n")
+	}
+	stdoutR.Close()
+	stderrR.Close()
+
+	return err
+}
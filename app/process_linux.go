@@ -0,0 +1,99 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// How long processes get to exit after SIGTERM before they are sent SIGKILL.
+const killGracePeriod = 5 * time.Second
+
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup terminates every process still running in cmd's process
+// group, reporting each one to log.
+func killProcessGroup(cmd *exec.Cmd, log io.Writer) {
+	pgid := cmd.Process.Pid
+
+	procs := processGroupMembers(pgid)
+	if len(procs) == 0 {
+		return
+	}
+
+	for _, proc := range procs {
+		fmt.Fprintf(log, "Killing process %d: %s\n", proc.pid, proc.cmdline)
+	}
+
+	syscall.Kill(-pgid, syscall.SIGTERM)
+
+	deadline := time.Now().Add(killGracePeriod)
+	for time.Now().Before(deadline) {
+		if len(processGroupMembers(pgid)) == 0 {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	fmt.Fprintf(log, "Processes did not exit after %v; sending SIGKILL\n", killGracePeriod)
+	syscall.Kill(-pgid, syscall.SIGKILL)
+}
+
+type process struct {
+	pid     int
+	cmdline string
+}
+
+// processGroupMembers lists the live (non-zombie) processes in a process
+// group by scanning /proc.
+func processGroupMembers(pgid int) []process {
+	statPaths, _ := filepath.Glob("/proc/[0-9]*/stat")
+
+	var result []process
+	for _, statPath := range statPaths {
+		stat, err := ioutil.ReadFile(statPath)
+		if err != nil {
+			continue
+		}
+
+		// The command name is in parentheses and may contain spaces, so
+		// parse the fields after the last closing parenthesis:
+		// state ppid pgrp ...
+		closeParen := bytes.LastIndexByte(stat, ')')
+		if closeParen < 0 {
+			continue
+		}
+		fields := strings.Fields(string(stat[closeParen+1:]))
+		if len(fields) < 3 || fields[0] == "Z" {
+			continue
+		}
+		if pgrp, _ := strconv.Atoi(fields[2]); pgrp != pgid {
+			continue
+		}
+
+		dir := filepath.Dir(statPath)
+		pid, _ := strconv.Atoi(filepath.Base(dir))
+
+		cmdline, _ := ioutil.ReadFile(filepath.Join(dir, "cmdline"))
+		cmdlineString := strings.TrimSpace(string(bytes.ReplaceAll(cmdline, []byte{0}, []byte{' '})))
+		if cmdlineString == "" {
+			cmdlineString = string(stat[bytes.IndexByte(stat, '(')+1 : closeParen])
+		}
+
+		result = append(result, process{
+			pid:     pid,
+			cmdline: cmdlineString,
+		})
+	}
+
+	return result
+}
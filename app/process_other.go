@@ -0,0 +1,16 @@
+// +build !linux
+
+package app
+
+import (
+	"io"
+	"os/exec"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills the command's process. Other platforms have no
+// process group support yet, so any children it started are left running.
+func killProcessGroup(cmd *exec.Cmd, log io.Writer) {
+	cmd.Process.Kill()
+}
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"bytes"
+	"io"
+)
+
+// Redactor replaces secrets with "***" in everything written through it. A
+// secret can be split across writes, so the end of a write is held back
+// while it could be the start of a secret. Call Flush once writing is done
+// to write out whatever is left.
+type Redactor struct {
+	W io.Writer
+
+	secrets [][]byte
+	pending []byte
+}
+
+var _ io.Writer = &Redactor{}
+
+func NewRedactor(w io.Writer, secrets []string) *Redactor {
+	r := &Redactor{W: w}
+	for _, secret := range secrets {
+		if secret != "" {
+			r.secrets = append(r.secrets, []byte(secret))
+		}
+	}
+
+	return r
+}
+
+func (r *Redactor) Write(p []byte) (n int, err error) {
+	if len(r.secrets) == 0 {
+		return r.W.Write(p)
+	}
+
+	r.pending = r.redact(append(r.pending, p...))
+
+	keep := r.partialSecretLength(r.pending)
+	out := r.pending[:len(r.pending)-keep]
+	r.pending = append([]byte{}, r.pending[len(r.pending)-keep:]...)
+
+	if len(out) > 0 {
+		if _, err := r.W.Write(out); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Flush writes out anything being held back.
+func (r *Redactor) Flush() error {
+	if len(r.pending) == 0 {
+		return nil
+	}
+
+	_, err := r.W.Write(r.pending)
+	r.pending = nil
+
+	return err
+}
+
+func (r *Redactor) redact(b []byte) []byte {
+	for _, secret := range r.secrets {
+		b = bytes.Replace(b, secret, []byte("***"), -1)
+	}
+
+	return b
+}
+
+// partialSecretLength finds the longest end of b that could be the start of
+// a secret.
+func (r *Redactor) partialSecretLength(b []byte) int {
+	longest := 0
+	for _, secret := range r.secrets {
+		for n := len(secret) - 1; n > longest; n-- {
+			if n <= len(b) && bytes.HasPrefix(secret, b[len(b)-n:]) {
+				longest = n
+				break
+			}
+		}
+	}
+
+	return longest
+}
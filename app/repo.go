@@ -1,5 +1,19 @@
 package app
 
+import "github.com/frc-2175/benkins/shared"
+
 type Repo struct {
 	URL string `toml:"url"`
+
+	// Name overrides the project name reported to the server. If it is
+	// empty, the name is derived from the path of URL.
+	Name string `toml:"name"`
+}
+
+func (r Repo) ProjectName() shared.ProjectName {
+	if r.Name != "" {
+		return shared.NewProjectNameFromPlain(r.Name)
+	}
+
+	return ProjectName(r.URL)
 }
@@ -0,0 +1,317 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/frc-2175/benkins/shared"
+)
+
+// How long an idle worker waits before asking the server for work again
+const claimInterval = 10 * time.Second
+
+// Runner watches repos for new commits and queues jobs for them on the
+// server, and runs the jobs the server hands out on a pool of workers.
+type Runner struct {
+	RunnerConfig
+
+	mirrorsMutex sync.Mutex
+	mirrors      map[string]*Mirror
+
+	// The leases on the jobs this runner is working on, which the heartbeat
+	// renews
+	leasesMutex sync.Mutex
+	leases      map[string]bool
+}
+
+func NewRunner(config RunnerConfig) *Runner {
+	if config.Concurrency < 1 {
+		config.Concurrency = 1
+	}
+
+	// Jobs can ask for a particular OS or architecture without anyone
+	// having to label their runners with them
+	config.Labels = append(append([]string{}, config.Labels...),
+		"os:"+runtime.GOOS,
+		"arch:"+runtime.GOARCH,
+	)
+
+	return &Runner{
+		RunnerConfig: config,
+		mirrors:      map[string]*Mirror{},
+		leases:       map[string]bool{},
+	}
+}
+
+// Run polls every minute and runs jobs until ctx is cancelled, then waits for
+// the running jobs to stop.
+func (r *Runner) Run(ctx context.Context) {
+	fmt.Printf("Runner labels: %s\n", strings.Join(r.Labels, ", "))
+
+	go r.heartbeat()
+
+	var workers sync.WaitGroup
+	for i := 0; i < r.Concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			r.work(ctx)
+		}()
+	}
+
+	ticker := time.NewTicker(time.Minute * 1)
+	defer ticker.Stop()
+
+poll:
+	for {
+		for _, repoConfig := range r.Repos {
+			if ctx.Err() != nil {
+				break poll
+			}
+
+			r.poll(repoConfig)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			break poll
+		}
+	}
+
+	workers.Wait()
+}
+
+// heartbeat checks in with the server every minute, renewing the leases on
+// the jobs this runner is working on. If the runner stops checking in, the
+// server gives its jobs to someone else.
+func (r *Runner) heartbeat() {
+	for {
+		url := BuildUrl(r.ServerUrl, "api")
+		q := url.Query()
+		q.Add("name", r.Name)
+		for _, label := range r.Labels {
+			q.Add("label", label)
+		}
+		for _, lease := range r.heldLeases() {
+			q.Add("lease", lease)
+		}
+		url.RawQuery = q.Encode()
+
+		res, err := authedGet(url, r.Password)
+		if err != nil {
+			fmt.Printf("WARNING: failed to check in with the server: %v\n", err)
+		} else {
+			if res.StatusCode != http.StatusOK {
+				fmt.Printf("WARNING: failed to check in with the server: got status %s\n", res.Status)
+			}
+			res.Body.Close()
+		}
+
+		time.Sleep(1 * time.Minute)
+	}
+}
+
+func (r *Runner) holdLease(lease string) {
+	r.leasesMutex.Lock()
+	defer r.leasesMutex.Unlock()
+
+	r.leases[lease] = true
+}
+
+func (r *Runner) releaseLease(lease string) {
+	r.leasesMutex.Lock()
+	defer r.leasesMutex.Unlock()
+
+	delete(r.leases, lease)
+}
+
+func (r *Runner) heldLeases() []string {
+	r.leasesMutex.Lock()
+	defer r.leasesMutex.Unlock()
+
+	var leases []string
+	for lease := range r.leases {
+		leases = append(leases, lease)
+	}
+
+	return leases
+}
+
+// poll asks the server to queue a job for every branch head in repoConfig.
+// The server skips commits it has already built or queued.
+func (r *Runner) poll(repoConfig Repo) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			fmt.Fprintf(os.Stderr, "PANIC RECOVERED: %v", recovered)
+		}
+	}()
+
+	projectName := repoConfig.ProjectName()
+
+	// Listing the remote's refs is cheap, so this doesn't touch the mirror.
+	// The mirror is only fetched once a worker claims a job.
+	fmt.Printf("\nChecking for new commits in %v...\n", repoConfig.URL)
+	heads, err := ListRemoteBranches(repoConfig.URL)
+	must(err)
+
+	queued := 0
+	for _, head := range heads {
+		branch := head.Name().Short()
+		hash := head.Hash().String()
+
+		res, err := authedPostForm(BuildUrl(r.ServerUrl, "queue"), r.Password, url.Values{
+			"project": {projectName.Encoded()},
+			"hash":    {hash},
+			"branch":  {branch},
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: failed to queue commit %v: %v\n", hash, err)
+			continue
+		}
+
+		switch res.StatusCode {
+		case http.StatusOK:
+		case http.StatusCreated:
+			fmt.Printf("Queued branch %v (commit %v).\n", branch, hash)
+			queued++
+		default:
+			fmt.Fprintf(os.Stderr, "WARNING: got unexpected status code when queueing commit %v: %v\n", hash, res.StatusCode)
+			dump, _ := httputil.DumpResponse(res, true)
+			fmt.Fprintf(os.Stderr, string(dump)+"\n")
+		}
+		res.Body.Close()
+	}
+
+	if queued == 0 {
+		fmt.Printf("No new commits.\n")
+	}
+}
+
+// work claims jobs from the server and runs them until ctx is cancelled.
+func (r *Runner) work(ctx context.Context) {
+	for ctx.Err() == nil {
+		job, ok := r.claim()
+		if !ok {
+			select {
+			case <-time.After(claimInterval):
+			case <-ctx.Done():
+			}
+			continue
+		}
+
+		r.holdLease(job.Lease)
+		r.RunJob(ctx, job)
+		r.releaseLease(job.Lease)
+	}
+}
+
+// claim asks the server for a job for one of this runner's repos that this
+// runner's labels allow it to run.
+func (r *Runner) claim() (Job, bool) {
+	form := url.Values{
+		"runner": {r.Name},
+		"label":  r.Labels,
+	}
+	reposByProject := map[shared.ProjectName]Repo{}
+	for _, repoConfig := range r.Repos {
+		form.Add("project", repoConfig.ProjectName().Encoded())
+		reposByProject[repoConfig.ProjectName()] = repoConfig
+	}
+
+	res, err := authedPostForm(BuildUrl(r.ServerUrl, "queue", "claim"), r.Password, form)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: failed to ask the server for a job: %v\n", err)
+		return Job{}, false
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNoContent {
+		return Job{}, false
+	}
+	if res.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "WARNING: got unexpected status code when asking the server for a job: %v\n", res.StatusCode)
+		dump, _ := httputil.DumpResponse(res, true)
+		fmt.Fprintf(os.Stderr, string(dump)+"\n")
+		return Job{}, false
+	}
+
+	var claimed shared.ClaimedJob
+	err = json.NewDecoder(res.Body).Decode(&claimed)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: failed to read job from server: %v\n", err)
+		return Job{}, false
+	}
+
+	repoConfig, ok := reposByProject[claimed.Project]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "WARNING: the server sent a job for a project this runner doesn't watch: %v\n", claimed.Project.Decoded())
+		return Job{}, false
+	}
+
+	mirror, err := r.mirror(repoConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: failed to open mirror of %v: %v\n", repoConfig.URL, err)
+		return Job{}, false
+	}
+
+	return Job{
+		Repo:    repoConfig,
+		Mirror:  mirror,
+		Branch:  claimed.Branch,
+		Hash:    claimed.Hash,
+		Lease:   claimed.Lease,
+		Rerun:   claimed.Rerun,
+		Attempt: claimed.Attempt,
+		RunId:   claimed.RunId,
+	}, true
+}
+
+// release hands a job back to the server because this runner's labels don't
+// match the job's runs-on, or because the runner is shutting down. The server
+// only offers the job to runners with those labels from then on.
+func (r *Runner) release(job Job, runsOn []string) error {
+	form := url.Values{
+		"project": {job.Repo.ProjectName().Encoded()},
+		"hash":    {job.Hash},
+		"runs-on": runsOn,
+	}
+
+	res, err := leasedPost(BuildUrl(r.ServerUrl, "queue", "release"), "application/x-www-form-urlencoded", r.Password, job.Lease, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		dump, _ := httputil.DumpResponse(res, true)
+		return fmt.Errorf("did not receive success from server: \n%s", dump)
+	}
+
+	return nil
+}
+
+func (r *Runner) mirror(repoConfig Repo) (*Mirror, error) {
+	r.mirrorsMutex.Lock()
+	defer r.mirrorsMutex.Unlock()
+
+	mirror, ok := r.mirrors[repoConfig.URL]
+	if !ok {
+		var err error
+		mirror, err = OpenMirror(r.CacheDir, repoConfig.URL)
+		if err != nil {
+			return nil, err
+		}
+		r.mirrors[repoConfig.URL] = mirror
+	}
+
+	return mirror, nil
+}
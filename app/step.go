@@ -0,0 +1,169 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/frc-2175/benkins/ansicolors"
+	"github.com/frc-2175/benkins/shared"
+)
+
+type Step struct {
+	Name            string            `toml:"name"`
+	Command         []string          `toml:"command"`
+	Dir             string            `toml:"dir"`
+	Env             map[string]string `toml:"env"`
+	ContinueOnError bool              `toml:"continue_on_error"`
+	Timeout         time.Duration     `toml:"timeout"`
+
+	// Secrets names secrets stored on the server for the project. The step
+	// gets each one as an environment variable of the same name.
+	Secrets []string `toml:"secrets"`
+}
+
+func (s Step) DisplayName() string {
+	if s.Name != "" {
+		return s.Name
+	}
+
+	return strings.Join(s.Command, " ")
+}
+
+// RunSteps runs each step in order in the workspace at dir, marking the
+// boundaries of each step in the output. Once a step fails, the remaining
+// steps are skipped unless the failed step has ContinueOnError set. If ctx
+// expires, the running step is stopped and reported as timed out. If
+// progress is not nil, it is called with the results so far after each step.
+// Each step gets the secrets it names, and every secret is hidden in the
+// output of all steps.
+func RunSteps(ctx context.Context, steps []Step, dir string, env []string, secrets map[string]string, stdout, stderr io.Writer, progress func([]shared.StepResult)) []shared.StepResult {
+	var results []shared.StepResult
+
+	failed := false
+	for i, step := range steps {
+		if failed {
+			color.New(color.FgHiBlack).Fprintf(stdout, "\n==> Skipping step %d/%d: %s\n", i+1, len(steps), step.DisplayName())
+			results = append(results, shared.StepResult{
+				Name:   step.DisplayName(),
+				Status: shared.StatusSkipped,
+			})
+			if progress != nil {
+				progress(results)
+			}
+			continue
+		}
+
+		color.New(color.Bold, color.FgCyan).Fprintf(stdout, "\n==> Step %d/%d: %s\n", i+1, len(steps), step.DisplayName())
+
+		// Keep the step's own output so that errors in it can be pointed
+		// out in the files they refer to
+		stepOutput := &LogBuffer{}
+		result := runStep(ctx, step, dir, env, secrets, io.MultiWriter(stdout, stepOutput), io.MultiWriter(stderr, stepOutput))
+		result.Annotations = shared.ParseAnnotations(
+			string(ansicolors.Strip(stepOutput.Bytes())),
+			dir,
+			filepath.Join(dir, step.Dir),
+			result.Status != shared.StatusSuccess,
+		)
+		if result.Status == shared.StatusSuccess {
+			color.New(color.FgGreen, color.Bold).Fprintf(stdout, "<== Step %s succeeded in %v.\n", step.DisplayName(), result.Duration)
+		} else if result.Status == shared.StatusTimedOut {
+			color.New(color.FgRed, color.Bold).Fprintf(stderr, "<== Step %s timed out after %v.\n", step.DisplayName(), result.Duration)
+			failed = true
+		} else {
+			color.New(color.FgRed, color.Bold).Fprintf(stderr, "<== Step %s failed with exit code %v in %v.\n", step.DisplayName(), result.ExitCode, result.Duration)
+
+			if step.ContinueOnError {
+				fmt.Fprintf(stdout, "Continuing because continue_on_error is set.\n")
+			} else {
+				failed = true
+			}
+		}
+
+		results = append(results, result)
+		if progress != nil {
+			progress(results)
+		}
+	}
+
+	return results
+}
+
+func runStep(ctx context.Context, step Step, dir string, env []string, secrets map[string]string, stdout, stderr io.Writer) (result shared.StepResult) {
+	result = shared.StepResult{
+		Name:            step.DisplayName(),
+		Status:          shared.StatusFailure,
+		ExitCode:        -1,
+		ContinueOnError: step.ContinueOnError,
+	}
+
+	if len(step.Command) == 0 || step.Command[0] == "" {
+		fmt.Fprintf(stderr, "ERROR: step %s has no command\n", step.DisplayName())
+		return result
+	}
+
+	start := time.Now()
+	defer func() {
+		result.Duration = time.Since(start).Round(time.Millisecond)
+	}()
+
+	if step.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, step.Timeout)
+		defer cancel()
+	}
+
+	cmd := exec.Command(step.Command[0], step.Command[1:]...)
+	cmd.Env = append([]string{}, env...)
+	cmd.Dir = filepath.Join(dir, step.Dir)
+
+	var keys []string
+	for key := range step.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		cmd.Env = append(cmd.Env, key+"="+step.Env[key])
+	}
+	for _, name := range step.Secrets {
+		cmd.Env = append(cmd.Env, name+"="+secrets[name])
+	}
+
+	// Redact the process's own output, before colors can split up a secret
+	var secretValues []string
+	for _, value := range secrets {
+		secretValues = append(secretValues, value)
+	}
+	redactedOut := NewRedactor(stdout, secretValues)
+	redactedErr := NewRedactor(NewColorWriter(stderr, color.New(color.Bold, color.FgRed)), secretValues)
+
+	err := RunProcess(ctx, cmd, redactedOut, redactedErr)
+	redactedOut.Flush()
+	redactedErr.Flush()
+	if cmd.ProcessState == nil {
+		fmt.Fprintf(stderr, "ERROR starting step %s: %v\n", step.DisplayName(), err)
+		return result
+	}
+	if err != nil {
+		if _, isExitError := err.(*exec.ExitError); !isExitError {
+			fmt.Fprintf(stderr, "ERROR running step %s: %v\n", step.DisplayName(), err)
+			return result
+		}
+	}
+
+	result.ExitCode = cmd.ProcessState.ExitCode()
+	if ctx.Err() == context.DeadlineExceeded {
+		result.Status = shared.StatusTimedOut
+	} else if cmd.ProcessState.Success() {
+		result.Status = shared.StatusSuccess
+	}
+
+	return result
+}
@@ -0,0 +1,217 @@
+package forge
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+)
+
+// The kinds of forge a repo can be hosted on
+const (
+	KindGitHub = "github"
+	KindGitea  = "gitea"
+)
+
+// Forge is the service hosting a repo, like GitHub or Gitea, which Benkins
+// reports jobs to.
+type Forge interface {
+	// SetStatus sets the status of a commit in the repo.
+	SetStatus(sha string, status Status) error
+
+	// CommitUrl links to a commit in the forge's web UI.
+	CommitUrl(sha string) string
+}
+
+// CheckRunner is a Forge that can show jobs as check runs, with annotations
+// on the lines of code their errors point at.
+type CheckRunner interface {
+	CreateCheckRun(run GitHubCheckRun) (int64, error)
+	UpdateCheckRun(id int64, run GitHubCheckRun) (int, error)
+}
+
+type CommitState string
+
+const (
+	StatePending CommitState = "pending"
+	StateSuccess CommitState = "success"
+	StateFailure CommitState = "failure"
+	StateError   CommitState = "error"
+)
+
+type Status struct {
+	State       CommitState `json:"state"`
+	TargetUrl   string      `json:"target_url,omitempty"`
+	Description string      `json:"description,omitempty"`
+	Context     string      `json:"context"`
+}
+
+// Config sets up a repo's forge.
+type Config struct {
+	Token string `toml:"token"`
+
+	// ApiUrl is the base URL of the forge's API. GitHub defaults to
+	// api.github.com, and Gitea defaults to /api/v1 under WebUrl.
+	ApiUrl string `toml:"api_url"`
+
+	// WebUrl is the base URL of the forge's web UI. GitHub defaults to
+	// github.com. Gitea is self-hosted, so it has no default.
+	WebUrl string `toml:"web_url"`
+
+	// Repo is the repo's full name on the forge, like "frc-2175/robot".
+	Repo string `toml:"repo"`
+
+	// CheckRuns reports jobs as check runs, with annotations for the
+	// errors in their output, instead of as commit statuses. Only GitHub
+	// has check runs, and they can only be created with a GitHub App's
+	// token.
+	CheckRuns bool `toml:"check_runs"`
+}
+
+// New creates the Forge of the given kind for the repo in config.
+func New(kind string, config Config) (Forge, error) {
+	if config.Repo == "" {
+		return nil, fmt.Errorf("the repo's full name on %s must be set", kind)
+	}
+
+	switch kind {
+	case KindGitHub:
+		return newGitHubForge(config), nil
+	case KindGitea:
+		return newGiteaForge(config)
+	}
+
+	return nil, fmt.Errorf("unknown forge %q", kind)
+}
+
+// WebhookEvent is what the server needs to know about a webhook.
+type WebhookEvent struct {
+	// The event's name, like "push" or "ping"
+	Event string
+
+	// The repo's full name on the forge
+	Repo string
+
+	// The branch and commit to build, which are empty unless the event is
+	// a push to a branch
+	Branch string
+	Hash   string
+}
+
+// Webhooks reads the webhooks a kind of forge sends.
+type Webhooks interface {
+	// Parse reads the event a webhook is about.
+	Parse(header http.Header, body []byte) (WebhookEvent, error)
+
+	// Verify checks the webhook's signature against the secret shared
+	// with the forge.
+	Verify(header http.Header, body []byte, secret string) bool
+}
+
+// WebhooksFor returns the webhook format of the given kind of forge.
+func WebhooksFor(kind string) (Webhooks, bool) {
+	switch kind {
+	case KindGitHub:
+		return gitHubWebhooks{}, true
+	case KindGitea:
+		return giteaWebhooks{}, true
+	}
+
+	return nil, false
+}
+
+// The hash forges send as "after" when a branch is deleted
+const deletedHash = "0000000000000000000000000000000000000000"
+
+// pushPayload is the part of a push webhook that GitHub and Gitea have in
+// common.
+type pushPayload struct {
+	Ref        string `json:"ref"`
+	After      string `json:"after"`
+	Deleted    bool   `json:"deleted"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+func parsePush(event string, body []byte) (WebhookEvent, error) {
+	var payload pushPayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+
+	result := WebhookEvent{
+		Event: event,
+		Repo:  payload.Repository.FullName,
+	}
+
+	if event == "push" && strings.HasPrefix(payload.Ref, "refs/heads/") && !payload.Deleted && payload.After != deletedHash {
+		result.Branch = strings.TrimPrefix(payload.Ref, "refs/heads/")
+		result.Hash = payload.After
+	}
+
+	return result, nil
+}
+
+// validHmac checks a hex SHA-256 HMAC of body.
+func validHmac(body []byte, secret, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// sendJSON sends body as JSON to an API endpoint, and decodes the response
+// into result if it is not nil.
+func sendJSON(httpClient *http.Client, method, endpoint, token string, body, result interface{}, service string) error {
+	js, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(method, endpoint, bytes.NewBuffer(js))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "token "+token)
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || 299 < res.StatusCode {
+		dump, _ := httputil.DumpResponse(res, true)
+		return fmt.Errorf("Got non-success status code %v from %s:\n%s", res.StatusCode, service, dump)
+	}
+
+	if result != nil {
+		err = json.NewDecoder(res.Body).Decode(result)
+		if err != nil {
+			return fmt.Errorf("Failed to parse JSON response from %s: %v", service, err)
+		}
+	}
+
+	return nil
+}
+
+// commitUrl links to a commit in web UIs laid out like GitHub's, which
+// Gitea's is too.
+func commitUrl(webUrl, repo, sha string) string {
+	return strings.TrimSuffix(webUrl, "/") + "/" + repo + "/commit/" + sha
+}
@@ -0,0 +1,81 @@
+package forge
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// GiteaClient talks to the API of a Gitea server, or a Forgejo server, which
+// speaks the same API.
+type GiteaClient struct {
+	httpClient *http.Client
+	baseUrl    string
+	token      string
+}
+
+// NewGiteaClient creates a client for the API at baseUrl, which usually ends
+// in /api/v1.
+func NewGiteaClient(baseUrl, token string) *GiteaClient {
+	return &GiteaClient{
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		baseUrl:    baseUrl,
+		token:      token,
+	}
+}
+
+// CreateStatus sets the status of a commit in repo, which is the repo's full
+// name, like "frc-2175/robot".
+func (g *GiteaClient) CreateStatus(repo, sha string, status Status) error {
+	u, err := url.Parse(g.baseUrl)
+	if err != nil {
+		return err
+	}
+	u.Path = path.Join(u.Path, "repos", repo, "statuses", sha)
+
+	return sendJSON(g.httpClient, "POST", u.String(), g.token, status, nil, "Gitea")
+}
+
+// giteaForge reports to a repo on a Gitea server.
+type giteaForge struct {
+	client *GiteaClient
+	config Config
+}
+
+func newGiteaForge(config Config) (*giteaForge, error) {
+	if config.WebUrl == "" {
+		return nil, fmt.Errorf("web_url must be set for the Gitea server hosting %s", config.Repo)
+	}
+	if config.ApiUrl == "" {
+		config.ApiUrl = strings.TrimSuffix(config.WebUrl, "/") + "/api/v1"
+	}
+
+	return &giteaForge{
+		client: NewGiteaClient(config.ApiUrl, config.Token),
+		config: config,
+	}, nil
+}
+
+func (f *giteaForge) SetStatus(sha string, status Status) error {
+	return f.client.CreateStatus(f.config.Repo, sha, status)
+}
+
+func (f *giteaForge) CommitUrl(sha string) string {
+	return commitUrl(f.config.WebUrl, f.config.Repo, sha)
+}
+
+// giteaWebhooks reads Gitea's webhooks, which name the event in
+// X-Gitea-Event and are signed in X-Gitea-Signature. Forgejo sends these
+// headers too.
+type giteaWebhooks struct{}
+
+func (giteaWebhooks) Parse(header http.Header, body []byte) (WebhookEvent, error) {
+	return parsePush(header.Get("X-Gitea-Event"), body)
+}
+
+func (giteaWebhooks) Verify(header http.Header, body []byte, secret string) bool {
+	return validHmac(body, secret, header.Get("X-Gitea-Signature"))
+}
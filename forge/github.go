@@ -0,0 +1,202 @@
+package forge
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	GitHubBaseUrl = "https://api.github.com/"
+	GitHubWebUrl  = "https://github.com"
+)
+
+// GitHubClient talks to the GitHub REST API, or anything that speaks it,
+// like GitHub Enterprise.
+type GitHubClient struct {
+	httpClient *http.Client
+	baseUrl    string
+	token      string
+}
+
+// NewGitHubClient creates a client for the API at baseUrl, or for
+// github.com if baseUrl is empty.
+func NewGitHubClient(baseUrl, token string) *GitHubClient {
+	if baseUrl == "" {
+		baseUrl = GitHubBaseUrl
+	}
+
+	return &GitHubClient{
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		baseUrl:    baseUrl,
+		token:      token,
+	}
+}
+
+// CreateStatus sets the status of a commit in repo, which is the repo's full
+// name, like "frc-2175/robot".
+func (g *GitHubClient) CreateStatus(repo, sha string, status Status) error {
+	return g.do("POST", nil, status, "repos", repo, "statuses", sha)
+}
+
+type CheckRunStatus string
+
+const (
+	CheckRunInProgress CheckRunStatus = "in_progress"
+	CheckRunCompleted  CheckRunStatus = "completed"
+)
+
+type CheckRunConclusion string
+
+const (
+	ConclusionSuccess   CheckRunConclusion = "success"
+	ConclusionFailure   CheckRunConclusion = "failure"
+	ConclusionTimedOut  CheckRunConclusion = "timed_out"
+	ConclusionCancelled CheckRunConclusion = "cancelled"
+	ConclusionNeutral   CheckRunConclusion = "neutral"
+)
+
+// GitHub accepts at most this many annotations per request.
+const MaxAnnotationsPerRequest = 50
+
+type GitHubCheckRun struct {
+	Name        string             `json:"name,omitempty"`
+	HeadSha     string             `json:"head_sha,omitempty"`
+	DetailsUrl  string             `json:"details_url,omitempty"`
+	Status      CheckRunStatus     `json:"status,omitempty"`
+	Conclusion  CheckRunConclusion `json:"conclusion,omitempty"`
+	StartedAt   *time.Time         `json:"started_at,omitempty"`
+	CompletedAt *time.Time         `json:"completed_at,omitempty"`
+	Output      *GitHubCheckOutput `json:"output,omitempty"`
+}
+
+type GitHubCheckOutput struct {
+	Title       string                  `json:"title"`
+	Summary     string                  `json:"summary"`
+	Annotations []GitHubCheckAnnotation `json:"annotations,omitempty"`
+}
+
+type GitHubCheckAnnotation struct {
+	Path            string `json:"path"`
+	StartLine       int    `json:"start_line"`
+	EndLine         int    `json:"end_line"`
+	AnnotationLevel string `json:"annotation_level"`
+	Message         string `json:"message"`
+}
+
+// CreateCheckRun starts a check run on a commit in repo and returns its ID.
+// Check runs can only be created with a GitHub App's token.
+func (g *GitHubClient) CreateCheckRun(repo string, run GitHubCheckRun) (int64, error) {
+	var created struct {
+		Id int64 `json:"id"`
+	}
+
+	err := g.do("POST", &created, run, "repos", repo, "check-runs")
+	if err != nil {
+		return 0, err
+	}
+
+	return created.Id, nil
+}
+
+// UpdateCheckRun changes a check run. New annotations are added to the ones
+// the check run already has, and are sent in several requests if there are
+// too many for one. It returns how many of the annotations GitHub accepted,
+// which is some of them even if a later request fails.
+func (g *GitHubClient) UpdateCheckRun(repo string, id int64, run GitHubCheckRun) (int, error) {
+	checkRunPath := []string{"repos", repo, "check-runs", strconv.FormatInt(id, 10)}
+
+	if run.Output == nil {
+		return 0, g.do("PATCH", nil, run, checkRunPath...)
+	}
+
+	// Send the extra annotations first, so the last request is the one
+	// that sets the status
+	sent := 0
+	annotations := run.Output.Annotations
+	for len(annotations) > MaxAnnotationsPerRequest {
+		output := *run.Output
+		output.Annotations = annotations[:MaxAnnotationsPerRequest]
+		err := g.do("PATCH", nil, GitHubCheckRun{Output: &output}, checkRunPath...)
+		if err != nil {
+			return sent, err
+		}
+
+		sent += MaxAnnotationsPerRequest
+		annotations = annotations[MaxAnnotationsPerRequest:]
+	}
+
+	output := *run.Output
+	output.Annotations = annotations
+	run.Output = &output
+
+	err := g.do("PATCH", nil, run, checkRunPath...)
+	if err != nil {
+		return sent, err
+	}
+
+	return sent + len(annotations), nil
+}
+
+func (g *GitHubClient) do(method string, result interface{}, body interface{}, pathSegments ...string) error {
+	u, err := url.Parse(g.baseUrl)
+	if err != nil {
+		return err
+	}
+	u.Path = path.Join(append([]string{u.Path}, pathSegments...)...)
+
+	return sendJSON(g.httpClient, method, u.String(), g.token, body, result, "GitHub")
+}
+
+// gitHubForge reports to a repo on GitHub.
+type gitHubForge struct {
+	client *GitHubClient
+	config Config
+}
+
+func newGitHubForge(config Config) *gitHubForge {
+	if config.WebUrl == "" {
+		config.WebUrl = GitHubWebUrl
+	}
+
+	return &gitHubForge{
+		client: NewGitHubClient(config.ApiUrl, config.Token),
+		config: config,
+	}
+}
+
+func (f *gitHubForge) SetStatus(sha string, status Status) error {
+	return f.client.CreateStatus(f.config.Repo, sha, status)
+}
+
+func (f *gitHubForge) CommitUrl(sha string) string {
+	return commitUrl(f.config.WebUrl, f.config.Repo, sha)
+}
+
+func (f *gitHubForge) CreateCheckRun(run GitHubCheckRun) (int64, error) {
+	return f.client.CreateCheckRun(f.config.Repo, run)
+}
+
+func (f *gitHubForge) UpdateCheckRun(id int64, run GitHubCheckRun) (int, error) {
+	return f.client.UpdateCheckRun(f.config.Repo, id, run)
+}
+
+// gitHubWebhooks reads GitHub's webhooks, which name the event in
+// X-GitHub-Event and are signed in X-Hub-Signature-256.
+type gitHubWebhooks struct{}
+
+func (gitHubWebhooks) Parse(header http.Header, body []byte) (WebhookEvent, error) {
+	return parsePush(header.Get("X-GitHub-Event"), body)
+}
+
+func (gitHubWebhooks) Verify(header http.Header, body []byte, secret string) bool {
+	signature := header.Get("X-Hub-Signature-256")
+	if !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+
+	return validHmac(body, secret, strings.TrimPrefix(signature, "sha256="))
+}
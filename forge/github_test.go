@@ -0,0 +1,116 @@
+package forge
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+const testWebhookSecret = "hunter2"
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+
+	body, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return body
+}
+
+// gitHubSignature signs body the way GitHub does in X-Hub-Signature-256.
+func gitHubSignature(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestGitHubWebhooks(t *testing.T) {
+	push := readTestdata(t, "github-push.json")
+	deleted := readTestdata(t, "github-push-deleted.json")
+	tampered := bytes.Replace(push, []byte(`"refs/heads/main"`), []byte(`"refs/heads/evil"`), 1)
+
+	tests := []struct {
+		name      string
+		body      []byte
+		signature string
+		valid     bool
+		event     WebhookEvent
+	}{
+		{
+			name:      "valid signature",
+			body:      push,
+			signature: gitHubSignature(push, testWebhookSecret),
+			valid:     true,
+			event: WebhookEvent{
+				Event:  "push",
+				Repo:   "frc-2175/robot",
+				Branch: "main",
+				Hash:   "0d1a26e67d8f5eaf1f6ba5c57fc3c7d91ac0fd1c",
+			},
+		},
+		{
+			name:      "tampered body",
+			body:      tampered,
+			signature: gitHubSignature(push, testWebhookSecret),
+			valid:     false,
+		},
+		{
+			name:      "wrong secret",
+			body:      push,
+			signature: gitHubSignature(push, "not the secret"),
+			valid:     false,
+		},
+		{
+			name:  "missing signature",
+			body:  push,
+			valid: false,
+		},
+		{
+			name:      "deleted branch",
+			body:      deleted,
+			signature: gitHubSignature(deleted, testWebhookSecret),
+			valid:     true,
+			event: WebhookEvent{
+				Event: "push",
+				Repo:  "frc-2175/robot",
+			},
+		},
+	}
+
+	webhooks, ok := WebhooksFor(KindGitHub)
+	if !ok {
+		t.Fatal("no webhooks for GitHub")
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := http.Header{}
+			header.Set("X-GitHub-Event", "push")
+			if test.signature != "" {
+				header.Set("X-Hub-Signature-256", test.signature)
+			}
+
+			if valid := webhooks.Verify(header, test.body, testWebhookSecret); valid != test.valid {
+				t.Fatalf("Verify returned %v, expected %v", valid, test.valid)
+			}
+			if !test.valid {
+				return
+			}
+
+			event, err := webhooks.Parse(header, test.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if event != test.event {
+				t.Errorf("Parse returned %+v, expected %+v", event, test.event)
+			}
+		})
+	}
+}
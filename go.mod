@@ -5,6 +5,7 @@ go 1.13
 require (
 	github.com/fatih/color v1.9.0
 	github.com/gin-contrib/multitemplate v0.0.0-20191128031210-95dee0dedf35
+	github.com/gin-contrib/sse v0.1.0
 	github.com/gin-gonic/gin v1.5.0
 	github.com/pelletier/go-toml v1.6.0
 	github.com/spf13/cobra v0.0.5
@@ -0,0 +1,193 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/frc-2175/benkins/shared"
+)
+
+const defaultSMTPPort = 587
+
+// SMTPConfig says how to send email.
+type SMTPConfig struct {
+	Host string `toml:"host"`
+
+	// Defaults to 587, the usual port for submission with STARTTLS
+	Port int `toml:"port"`
+
+	// Username and Password log in to the server with PLAIN auth. Mail is
+	// sent without logging in if Username is empty.
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+
+	From string `toml:"from"`
+
+	// Insecure allows sending mail without STARTTLS when the server doesn't
+	// offer it, which is only a good idea for a local test server.
+	Insecure bool `toml:"insecure"`
+}
+
+// SendEmail sends a plain text email, upgrading the connection with
+// STARTTLS before logging in.
+func SendEmail(config SMTPConfig, to []string, subject, body string) error {
+	if len(to) == 0 {
+		return fmt.Errorf("no recipients for email %q", subject)
+	}
+
+	port := config.Port
+	if port == 0 {
+		port = defaultSMTPPort
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(config.Host, strconv.Itoa(port)), 30*time.Second)
+	if err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: config.Host}); err != nil {
+			return err
+		}
+	} else if !config.Insecure {
+		return fmt.Errorf("SMTP server %s does not support STARTTLS", config.Host)
+	}
+
+	if config.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", config.Username, config.Password, config.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(config.From); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", config.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&message, "\r\n")
+	message.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+
+	if _, err := w.Write(message.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+type EmailConfig struct {
+	SMTP SMTPConfig `toml:"smtp"`
+	To   []string   `toml:"to"`
+
+	// Branches limits emails to failures on these branches, which can be
+	// patterns like "release/*". Failures on any branch are sent if it is
+	// empty.
+	Branches []string `toml:"branches"`
+}
+
+// EmailNotifier sends an email as soon as a build fails.
+type EmailNotifier struct {
+	Config EmailConfig
+}
+
+func NewEmailNotifier(config EmailConfig) *EmailNotifier {
+	return &EmailNotifier{
+		Config: config,
+	}
+}
+
+// Notify emails about failed builds, and ignores everything else.
+func (n *EmailNotifier) Notify(event Event) error {
+	if event.Kind != EventFinished || !IsFailure(event.Status) || !n.wantsBranch(event.Branch) {
+		return nil
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Branch %s of %s has %s.\n\n", event.Branch, event.Project, failureVerb(event.Status))
+	fmt.Fprintf(&body, "Commit: %s\n", event.Hash)
+	fmt.Fprintf(&body, "Message: %s\n", strings.TrimSpace(event.CommitMessage))
+	if event.Duration > 0 {
+		fmt.Fprintf(&body, "Duration: %v\n", event.Duration)
+	}
+	if event.Runner != "" {
+		fmt.Fprintf(&body, "Runner: %s\n", event.Runner)
+	}
+	if event.Text != "" {
+		fmt.Fprintf(&body, "\n%s\n", strings.TrimSpace(event.Text))
+	}
+	fmt.Fprintf(&body, "\nView the full results: %s\n", event.Url)
+	if event.CommitUrl != "" {
+		fmt.Fprintf(&body, "View the commit: %s\n", event.CommitUrl)
+	}
+
+	subject := fmt.Sprintf("[Benkins] %s: %s %s (%s)", event.Project, event.Branch, failureVerb(event.Status), event.Hash[0:7])
+
+	return SendEmail(n.Config.SMTP, n.Config.To, subject, body.String())
+}
+
+func (n *EmailNotifier) wantsBranch(branch string) bool {
+	if len(n.Config.Branches) == 0 {
+		return true
+	}
+
+	for _, pattern := range n.Config.Branches {
+		if matched, _ := path.Match(pattern, branch); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsFailure is whether a build with this status needs someone's attention.
+func IsFailure(status shared.Status) bool {
+	switch status {
+	case shared.StatusFailure, shared.StatusTimedOut, shared.StatusErrored:
+		return true
+	}
+
+	return false
+}
+
+func failureVerb(status shared.Status) string {
+	switch status {
+	case shared.StatusTimedOut:
+		return "timed out"
+	case shared.StatusErrored:
+		return "errored"
+	}
+
+	return "failed"
+}
@@ -0,0 +1,171 @@
+package notify
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/frc-2175/benkins/shared"
+)
+
+// sentEmail is what smtpSink received in one SMTP session.
+type sentEmail struct {
+	From string
+	To   []string
+	Data string
+}
+
+// smtpSink accepts one email on a local port without TLS, like a test mail
+// server, and sends what it got on the returned channel.
+func smtpSink(t *testing.T) (SMTPConfig, <-chan sentEmail) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emails := make(chan sentEmail, 1)
+	go func() {
+		defer listener.Close()
+
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+		text := textproto.NewConn(conn)
+		var email sentEmail
+
+		text.PrintfLine("220 localhost ESMTP sink")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+
+			verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch verb {
+			case "EHLO", "HELO":
+				text.PrintfLine("250 localhost")
+			case "MAIL":
+				email.From = line[len("MAIL FROM:"):]
+				text.PrintfLine("250 OK")
+			case "RCPT":
+				email.To = append(email.To, line[len("RCPT TO:"):])
+				text.PrintfLine("250 OK")
+			case "DATA":
+				text.PrintfLine("354 Go ahead")
+				data, err := text.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				email.Data = string(data)
+				text.PrintfLine("250 OK")
+			case "QUIT":
+				text.PrintfLine("221 Bye")
+				emails <- email
+				return
+			default:
+				text.PrintfLine("502 Unknown command")
+			}
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	config := SMTPConfig{
+		Host:     "127.0.0.1",
+		Port:     addr.Port,
+		From:     "benkins@example.com",
+		Insecure: true,
+	}
+
+	return config, emails
+}
+
+func TestEmailFailure(t *testing.T) {
+	smtpConfig, emails := smtpSink(t)
+
+	n := NewEmailNotifier(EmailConfig{
+		SMTP: smtpConfig,
+		To:   []string{"mentors@example.com", "captain@example.com"},
+	})
+
+	err := n.Notify(Event{
+		Kind:          EventFinished,
+		Project:       "frc-2175/robot",
+		Branch:        "main",
+		Hash:          "6d7cf38d6505a4209fc3cd045667c3c72f09f1a8",
+		CommitMessage: "Tune the shooter\n",
+		Status:        shared.StatusFailure,
+		Duration:      42 * time.Second,
+		Runner:        "build-laptop",
+		Url:           "https://benkins.example.com/p/robot/6d7cf38",
+		CommitUrl:     "https://github.com/frc-2175/robot/commit/6d7cf38",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var email sentEmail
+	select {
+	case email = <-emails:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the SMTP sink never got an email")
+	}
+
+	if email.From != "<benkins@example.com>" {
+		t.Errorf("got sender %q", email.From)
+	}
+	if strings.Join(email.To, ",") != "<mentors@example.com>,<captain@example.com>" {
+		t.Errorf("got recipients %q", email.To)
+	}
+
+	for _, expected := range []string{
+		"To: mentors@example.com, captain@example.com\n",
+		"Subject: [Benkins] frc-2175/robot: main failed (6d7cf38)\n",
+		"Branch main of frc-2175/robot has failed.\n",
+		"Commit: 6d7cf38d6505a4209fc3cd045667c3c72f09f1a8\n",
+		"Message: Tune the shooter\n",
+		"Duration: 42s\n",
+		"Runner: build-laptop\n",
+		"View the full results: https://benkins.example.com/p/robot/6d7cf38\n",
+		"View the commit: https://github.com/frc-2175/robot/commit/6d7cf38\n",
+	} {
+		if !strings.Contains(email.Data, expected) {
+			t.Errorf("email is missing %q:\n%s", expected, email.Data)
+		}
+	}
+}
+
+func TestEmailIgnoresSuccess(t *testing.T) {
+	n := NewEmailNotifier(EmailConfig{
+		// Nothing listens here, so sending would fail
+		SMTP: SMTPConfig{Host: "127.0.0.1", Port: 1, Insecure: true},
+		To:   []string{"mentors@example.com"},
+	})
+
+	err := n.Notify(Event{
+		Kind:   EventFinished,
+		Branch: "main",
+		Hash:   "6d7cf38d6505a4209fc3cd045667c3c72f09f1a8",
+		Status: shared.StatusSuccess,
+	})
+	if err != nil {
+		t.Errorf("tried to email about a successful build: %v", err)
+	}
+}
+
+func TestEmailRequiresStartTLS(t *testing.T) {
+	smtpConfig, _ := smtpSink(t)
+	smtpConfig.Insecure = false
+
+	err := SendEmail(smtpConfig, []string{"mentors@example.com"}, "Hello", "Hello")
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("expected an error about STARTTLS, got %v", err)
+	}
+}
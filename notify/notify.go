@@ -0,0 +1,98 @@
+package notify
+
+import (
+	"time"
+
+	"github.com/frc-2175/benkins/shared"
+)
+
+type EventKind string
+
+const (
+	EventQueued   EventKind = "queued"
+	EventStarted  EventKind = "started"
+	EventFinished EventKind = "finished"
+)
+
+// Event describes something that happened to a build. Only finished events
+// have a final status and duration.
+type Event struct {
+	Kind EventKind
+	Time time.Time
+
+	Project       string
+	Branch        string
+	Hash          string
+	CommitMessage string
+	Author        string
+	AuthorEmail   string
+	Status        shared.Status
+	Duration      time.Duration
+
+	// PreviousStatus is the status of the branch's build before this one,
+	// or empty if there wasn't one.
+	PreviousStatus shared.Status
+
+	// The runner that ran the build, if it has started
+	Runner string
+
+	// Rerun is whether someone asked for the build to run again.
+	Rerun bool
+
+	// FailedStep is the step that failed the build, if one did, and
+	// FailedStepOutput is everything it printed, without colors.
+	FailedStep       string
+	FailedStepOutput string
+
+	// Attachments are small files from the build to include with
+	// notifications that can show them.
+	Attachments []Attachment
+
+	// SlackThreads maps Slack channel IDs to the messages already posted
+	// about the build. Slack notifiers add the messages they post to it, so
+	// it must not be nil for later messages to be threaded.
+	SlackThreads map[string]string
+
+	// Text is the custom notification text the job left in
+	// benkins-notification.txt, if any.
+	Text string
+
+	// Url is the build's results page on the Benkins server.
+	Url string
+
+	// CommitUrl is the commit's page on its forge, if it has one.
+	CommitUrl string
+}
+
+type Attachment struct {
+	Name    string
+	Content []byte
+}
+
+// Notifier tells people about builds.
+type Notifier interface {
+	Notify(event Event) error
+}
+
+// Config sets up zero or more notifiers of each kind.
+type Config struct {
+	Slack    []SlackConfig   `toml:"slack"`
+	Webhooks []WebhookConfig `toml:"webhook"`
+	Email    []EmailConfig   `toml:"email"`
+}
+
+// Notifiers creates the notifiers described by the config.
+func (c Config) Notifiers() []Notifier {
+	var notifiers []Notifier
+	for _, slack := range c.Slack {
+		notifiers = append(notifiers, NewSlackNotifier(slack))
+	}
+	for _, webhook := range c.Webhooks {
+		notifiers = append(notifiers, NewWebhookNotifier(webhook))
+	}
+	for _, email := range c.Email {
+		notifiers = append(notifiers, NewEmailNotifier(email))
+	}
+
+	return notifiers
+}
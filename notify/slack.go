@@ -0,0 +1,505 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/frc-2175/benkins/shared"
+)
+
+const SlackBaseUrl = "https://slack.com/api/"
+
+const defaultSlackLogLines = 30
+
+type SlackMessageRequest struct {
+	Channel string        `json:"channel"`
+	Text    string        `json:"text"`
+	Blocks  []*SlackBlock `json:"blocks,omitempty"`
+
+	// ThreadTs posts the message as a reply in the thread of another
+	// message.
+	ThreadTs string `json:"thread_ts,omitempty"`
+
+	// Ts is the message to replace when updating a message.
+	Ts string `json:"ts,omitempty"`
+}
+
+// SlackFileUpload is a file to share in a channel, as a snippet if it's
+// text.
+type SlackFileUpload struct {
+	Channel        string
+	ThreadTs       string
+	Filename       string
+	Title          string
+	InitialComment string
+	Content        []byte
+}
+
+// SlackMessage identifies a message that was posted to Slack.
+type SlackMessage struct {
+	Channel string `json:"channel"`
+	Ts      string `json:"ts"`
+}
+
+type SlackBlock struct {
+	Type string          `json:"type"`
+	Text SlackTextObject `json:"text"`
+}
+
+type SlackTextObject struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	Emoji    bool   `json:"emoji,omitempty"`
+	Verbatim bool   `json:"verbatim,omitempty"`
+}
+
+func TextBlock(format string, a ...interface{}) *SlackBlock {
+	if format == "" {
+		return nil
+	}
+
+	return &SlackBlock{
+		Type: "section",
+		Text: SlackTextObject{
+			Type: "mrkdwn",
+			Text: fmt.Sprintf(format, a...),
+		},
+	}
+}
+
+// SlackCommitLink shows a short commit hash, linked to the commit on its
+// forge if commitUrl is not empty.
+func SlackCommitLink(hash, commitUrl string) string {
+	if commitUrl == "" {
+		return hash[0:7]
+	}
+
+	return fmt.Sprintf("<%s|%s>", commitUrl, hash[0:7])
+}
+
+type SlackClient struct {
+	// BaseUrl is where the Slack Web API is, which can be pointed at a
+	// fake server for testing. Defaults to SlackBaseUrl.
+	BaseUrl string
+
+	httpClient *http.Client
+	token      string
+}
+
+func NewSlackClient(token string) *SlackClient {
+	return &SlackClient{
+		BaseUrl:    SlackBaseUrl,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		token:      token,
+	}
+}
+
+// SlackPostMessage posts a new message, or a reply if r.ThreadTs is set.
+func (s *SlackClient) SlackPostMessage(r SlackMessageRequest) (SlackMessage, error) {
+	return s.sendMessage("chat.postMessage", r)
+}
+
+// SlackUpdateMessage replaces the contents of the message ts in r.Channel.
+func (s *SlackClient) SlackUpdateMessage(ts string, r SlackMessageRequest) error {
+	r.Ts = ts
+	_, err := s.sendMessage("chat.update", r)
+
+	return err
+}
+
+// SlackUploadFile shares a file in a channel, or in a thread if
+// r.ThreadTs is set. Slack takes files in three steps: it hands out a URL
+// to upload the file to, and once the file is there, the upload is
+// completed by sharing it.
+func (s *SlackClient) SlackUploadFile(r SlackFileUpload) error {
+	form := url.Values{}
+	form.Set("filename", r.Filename)
+	form.Set("length", strconv.Itoa(len(r.Content)))
+
+	var upload struct {
+		UploadUrl string `json:"upload_url"`
+		FileId    string `json:"file_id"`
+	}
+	err := s.send("files.getUploadURLExternal", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()), &upload)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.httpClient.Post(upload.UploadUrl, "application/octet-stream", bytes.NewReader(r.Content))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || 299 < res.StatusCode {
+		dump, _ := httputil.DumpResponse(res, true)
+		return fmt.Errorf("Got non-success status code %v uploading a file to Slack:\n%s", res.StatusCode, dump)
+	}
+
+	type completedFile struct {
+		Id    string `json:"id"`
+		Title string `json:"title,omitempty"`
+	}
+	complete := struct {
+		Files          []completedFile `json:"files"`
+		ChannelId      string          `json:"channel_id"`
+		ThreadTs       string          `json:"thread_ts,omitempty"`
+		InitialComment string          `json:"initial_comment,omitempty"`
+	}{
+		Files:          []completedFile{{Id: upload.FileId, Title: r.Title}},
+		ChannelId:      r.Channel,
+		ThreadTs:       r.ThreadTs,
+		InitialComment: r.InitialComment,
+	}
+
+	js, err := json.Marshal(complete)
+	if err != nil {
+		return err
+	}
+
+	return s.send("files.completeUploadExternal", "application/json; charset=utf-8", bytes.NewReader(js), nil)
+}
+
+func (s *SlackClient) sendMessage(method string, r SlackMessageRequest) (SlackMessage, error) {
+	var nonNilBlocks []*SlackBlock
+
+	// Remove nil blocks
+	for _, block := range r.Blocks {
+		if block != nil {
+			nonNilBlocks = append(nonNilBlocks, block)
+		}
+	}
+	r.Blocks = nonNilBlocks
+
+	js, err := json.Marshal(r)
+	if err != nil {
+		return SlackMessage{}, err
+	}
+
+	var message SlackMessage
+	err = s.send(method, "application/json; charset=utf-8", bytes.NewBuffer(js), &message)
+
+	return message, err
+}
+
+// send calls a Web API method and checks that Slack was happy with it. If
+// response is not nil, the rest of Slack's response is decoded into it.
+func (s *SlackClient) send(method, contentType string, body io.Reader, response interface{}) error {
+	req, err := http.NewRequest("POST", strings.TrimSuffix(s.BaseUrl, "/")+"/"+method, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer "+s.token)
+
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	dumpBytes, _ := httputil.DumpResponse(res, true)
+	dump := string(dumpBytes)
+
+	if res.StatusCode < 200 || 299 < res.StatusCode {
+		return fmt.Errorf("Got non-success status code %v from Slack:\n%v", res.StatusCode, dump)
+	}
+
+	resBody, _ := ioutil.ReadAll(res.Body)
+	var responseBody struct {
+		Ok bool `json:"ok"`
+	}
+	err = json.Unmarshal(resBody, &responseBody)
+	if err != nil {
+		return fmt.Errorf("Failed to parse JSON response from Slack:\n%v", dump)
+	}
+
+	if !responseBody.Ok {
+		return fmt.Errorf("Got non-ok response from Slack:\n%v", dump)
+	}
+
+	if response != nil {
+		return json.Unmarshal(resBody, response)
+	}
+
+	return nil
+}
+
+// SlackPolicy decides which finished builds get posted to Slack.
+type SlackPolicy string
+
+const (
+	// Post about every build
+	SlackAlways SlackPolicy = "always"
+
+	// Post about builds that fail
+	SlackFailures SlackPolicy = "failures"
+
+	// Post when a branch breaks or is fixed
+	SlackChanges SlackPolicy = "changes"
+)
+
+type SlackConfig struct {
+	Token string `toml:"token"`
+
+	// The channel ID, NOT the channel name
+	Channel string `toml:"channel"`
+
+	// Defaults to always
+	Policy SlackPolicy `toml:"policy"`
+
+	// Mentions maps commit author emails to Slack user IDs, so authors get
+	// mentioned when their commits fail.
+	Mentions map[string]string `toml:"mentions"`
+
+	// LogLines is how much of a failed step's output to share. Defaults
+	// to 30.
+	LogLines int `toml:"log_lines"`
+
+	// Overrides SlackBaseUrl, for testing against a fake Slack
+	BaseUrl string `toml:"base_url"`
+}
+
+// SlackNotifier posts a message about builds to a Slack channel.
+type SlackNotifier struct {
+	Client   *SlackClient
+	Channel  string
+	Policy   SlackPolicy
+	Mentions map[string]string
+	LogLines int
+}
+
+func NewSlackNotifier(config SlackConfig) *SlackNotifier {
+	mentions := map[string]string{}
+	for email, userId := range config.Mentions {
+		mentions[strings.ToLower(email)] = userId
+	}
+
+	client := NewSlackClient(config.Token)
+	if config.BaseUrl != "" {
+		client.BaseUrl = config.BaseUrl
+	}
+
+	return &SlackNotifier{
+		Client:   client,
+		Channel:  config.Channel,
+		Policy:   config.Policy,
+		Mentions: mentions,
+		LogLines: config.LogLines,
+	}
+}
+
+// Wants is whether the notifier's policy posts about the event.
+func (n *SlackNotifier) Wants(event Event) bool {
+	if event.Kind != EventFinished {
+		return false
+	}
+
+	switch n.Policy {
+	case SlackFailures:
+		return IsFailure(event.Status)
+	case SlackChanges:
+		return IsFailure(event.Status) != IsFailure(event.PreviousStatus)
+	}
+
+	return true
+}
+
+// Notify posts a running message when a build starts, if the policy posts
+// about every build, and updates it when the build finishes. Builds that
+// already have a message in the channel, like reruns, update that message
+// and reply in its thread instead.
+func (n *SlackNotifier) Notify(event Event) error {
+	ts := event.SlackThreads[n.Channel]
+
+	switch event.Kind {
+	case EventStarted:
+		// Other policies can't tell if they want the build until it's done
+		if n.Policy != "" && n.Policy != SlackAlways {
+			return nil
+		}
+
+		message := n.message(event, ":large_yellow_circle:", "Running", event.Author)
+		if ts == "" {
+			return n.post(event, message)
+		}
+
+		if err := n.Client.SlackUpdateMessage(ts, message); err != nil {
+			return err
+		}
+
+		again := "Started again"
+		if event.Rerun {
+			again = "Rerun started"
+		}
+		return n.reply(ts, ":large_yellow_circle: %s on %s.", again, event.Runner)
+	case EventFinished:
+		if !n.Wants(event) {
+			return nil
+		}
+
+		emoji, status := slackStatus(event)
+
+		// Only ping the author when their commit needs attention
+		author := event.Author
+		if userId, ok := n.Mentions[strings.ToLower(event.AuthorEmail)]; ok && IsFailure(event.Status) {
+			author = fmt.Sprintf("<@%s>", userId)
+		}
+
+		message := n.message(event, emoji, status, author)
+		if ts == "" {
+			if err := n.post(event, message); err != nil {
+				return err
+			}
+			ts = event.SlackThreads[n.Channel]
+		} else {
+			if err := n.Client.SlackUpdateMessage(ts, message); err != nil {
+				return err
+			}
+			if event.Rerun {
+				if err := n.reply(ts, "%s Rerun finished: %s", emoji, status); err != nil {
+					return err
+				}
+			}
+		}
+
+		if ts == "" {
+			return nil
+		}
+
+		if IsFailure(event.Status) && event.FailedStep != "" {
+			if err := n.shareFailedStep(ts, event); err != nil {
+				return err
+			}
+		}
+		for _, attachment := range event.Attachments {
+			err := n.Client.SlackUploadFile(SlackFileUpload{
+				Channel:  n.Channel,
+				ThreadTs: ts,
+				Filename: attachment.Name,
+				Title:    attachment.Name,
+				Content:  attachment.Content,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// shareFailedStep replies with the end of the failed step's output as a
+// snippet, so people can see what went wrong without leaving Slack.
+func (n *SlackNotifier) shareFailedStep(ts string, event Event) error {
+	output := strings.TrimRight(event.FailedStepOutput, "\n")
+	if strings.TrimSpace(output) == "" {
+		return n.reply(ts, "Step *%s* failed.", event.FailedStep)
+	}
+
+	lines := n.LogLines
+	if lines <= 0 {
+		lines = defaultSlackLogLines
+	}
+
+	outputLines := strings.Split(output, "\n")
+	comment := fmt.Sprintf("Step *%s* failed.", event.FailedStep)
+	if len(outputLines) > lines {
+		outputLines = outputLines[len(outputLines)-lines:]
+		comment = fmt.Sprintf("Step *%s* failed. Here are the last %d lines of its output:", event.FailedStep, lines)
+	}
+
+	return n.Client.SlackUploadFile(SlackFileUpload{
+		Channel:        n.Channel,
+		ThreadTs:       ts,
+		Filename:       fmt.Sprintf("%s-%s.log", event.Hash[0:7], slackFileSafe(event.FailedStep)),
+		Title:          fmt.Sprintf("Output of %s", event.FailedStep),
+		InitialComment: comment,
+		Content:        []byte(strings.Join(outputLines, "\n") + "\n"),
+	})
+}
+
+// slackFileSafe replaces anything but letters, digits, dashes, and
+// underscores so name can go in a filename.
+func slackFileSafe(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func (n *SlackNotifier) message(event Event, emoji, status, author string) SlackMessageRequest {
+	return SlackMessageRequest{
+		Channel: n.Channel,
+		Text:    fmt.Sprintf("%s Branch %s (Commit %s) %s", emoji, event.Branch, event.Hash[0:7], status),
+		Blocks: []*SlackBlock{
+			TextBlock("*%s Branch %s (Commit %s) %s*", emoji, event.Branch, SlackCommitLink(event.Hash, event.CommitUrl), status),
+			TextBlock("Message: %s", event.CommitMessage),
+			authorBlock(author),
+			TextBlock(event.Text),
+			TextBlock("<%s|View the full results>", event.Url),
+		},
+	}
+}
+
+// post posts a new message about the build and remembers it, so that later
+// messages about the build go in its thread.
+func (n *SlackNotifier) post(event Event, message SlackMessageRequest) error {
+	posted, err := n.Client.SlackPostMessage(message)
+	if err != nil {
+		return err
+	}
+
+	if event.SlackThreads != nil {
+		event.SlackThreads[n.Channel] = posted.Ts
+	}
+
+	return nil
+}
+
+func (n *SlackNotifier) reply(ts string, format string, a ...interface{}) error {
+	_, err := n.Client.SlackPostMessage(SlackMessageRequest{
+		Channel:  n.Channel,
+		Text:     fmt.Sprintf(format, a...),
+		ThreadTs: ts,
+	})
+
+	return err
+}
+
+func slackStatus(event Event) (string, string) {
+	switch event.Status {
+	case shared.StatusTimedOut:
+		return ":stopwatch:", "Timed out"
+	case shared.StatusFailure:
+		return ":x:", "Failure"
+	case shared.StatusErrored:
+		return ":warning:", "Errored"
+	}
+
+	if IsFailure(event.PreviousStatus) {
+		return ":white_check_mark:", "Fixed!"
+	}
+
+	return ":white_check_mark:", "Success!"
+}
+
+func authorBlock(author string) *SlackBlock {
+	if author == "" {
+		return nil
+	}
+
+	return TextBlock("Author: %s", author)
+}
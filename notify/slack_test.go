@@ -0,0 +1,222 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/frc-2175/benkins/shared"
+)
+
+// slackCall is one request to fakeSlack, with its body decoded.
+type slackCall struct {
+	Method string
+	Body   map[string]interface{}
+}
+
+// fakeSlack answers the Web API methods the notifier uses and records the
+// calls it gets.
+type fakeSlack struct {
+	server *httptest.Server
+
+	mutex    sync.Mutex
+	calls    []slackCall
+	messages int
+	files    int
+}
+
+func newFakeSlack() *fakeSlack {
+	slack := &fakeSlack{}
+	slack.server = httptest.NewServer(slack)
+	return slack
+}
+
+func (s *fakeSlack) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	raw, _ := ioutil.ReadAll(r.Body)
+	method := strings.TrimPrefix(r.URL.Path, "/")
+
+	body := map[string]interface{}{}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		json.Unmarshal(raw, &body)
+	} else if form, err := url.ParseQuery(string(raw)); err == nil && !strings.HasPrefix(method, "upload/") {
+		for key := range form {
+			body[key] = form.Get(key)
+		}
+	}
+	s.calls = append(s.calls, slackCall{Method: method, Body: body})
+
+	var response map[string]interface{}
+	switch {
+	case method == "chat.postMessage":
+		s.messages++
+		response = map[string]interface{}{"ok": true, "channel": body["channel"], "ts": fmt.Sprintf("1000.%04d", s.messages)}
+	case method == "chat.update":
+		response = map[string]interface{}{"ok": true, "channel": body["channel"], "ts": body["ts"]}
+	case method == "files.getUploadURLExternal":
+		s.files++
+		id := fmt.Sprintf("F%d", s.files)
+		response = map[string]interface{}{"ok": true, "upload_url": s.server.URL + "/upload/" + id, "file_id": id}
+	case method == "files.completeUploadExternal":
+		response = map[string]interface{}{"ok": true}
+	case strings.HasPrefix(method, "upload/"):
+		w.Write([]byte("OK"))
+		return
+	default:
+		response = map[string]interface{}{"ok": false, "error": "unknown_method"}
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// callsTo returns the calls to a method, in order.
+func (s *fakeSlack) callsTo(method string) []slackCall {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var calls []slackCall
+	for _, call := range s.calls {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+
+	return calls
+}
+
+func newTestSlackNotifier(slack *fakeSlack) *SlackNotifier {
+	return NewSlackNotifier(SlackConfig{
+		Token:   "xoxb-test",
+		Channel: "C0123",
+		BaseUrl: slack.server.URL,
+	})
+}
+
+func slackTestEvent(kind EventKind, threads map[string]string) Event {
+	return Event{
+		Kind:          kind,
+		Project:       "frc-2175/robot",
+		Branch:        "main",
+		Hash:          "6d7cf38d6505a4209fc3cd045667c3c72f09f1a8",
+		CommitMessage: "Tune the shooter",
+		Author:        "Ben",
+		Status:        shared.StatusRunning,
+		Runner:        "build-laptop",
+		Url:           "https://benkins.example.com/p/robot/6d7cf38",
+		SlackThreads:  threads,
+	}
+}
+
+func TestSlackUpdatesRunningMessage(t *testing.T) {
+	slack := newFakeSlack()
+	defer slack.server.Close()
+	n := newTestSlackNotifier(slack)
+
+	threads := map[string]string{}
+	if err := n.Notify(slackTestEvent(EventStarted, threads)); err != nil {
+		t.Fatal(err)
+	}
+
+	posts := slack.callsTo("chat.postMessage")
+	if len(posts) != 1 {
+		t.Fatalf("got %d posts for the started build, expected 1", len(posts))
+	}
+	if text := posts[0].Body["text"]; !strings.Contains(text.(string), "Running") {
+		t.Errorf("started message says %q", text)
+	}
+	ts := threads["C0123"]
+	if ts != "1000.0001" {
+		t.Fatalf("the running message's ts %q wasn't remembered", ts)
+	}
+
+	finished := slackTestEvent(EventFinished, threads)
+	finished.Status = shared.StatusFailure
+	finished.FailedStep = "Build"
+	finished.FailedStepOutput = "error: cannot find symbol\n"
+	finished.Attachments = []Attachment{{Name: "report.txt", Content: []byte("coverage: 42%\n")}}
+	if err := n.Notify(finished); err != nil {
+		t.Fatal(err)
+	}
+
+	if posts := slack.callsTo("chat.postMessage"); len(posts) != 1 {
+		t.Errorf("the finished build was posted again instead of updating the running message: %+v", posts[1:])
+	}
+
+	updates := slack.callsTo("chat.update")
+	if len(updates) != 1 {
+		t.Fatalf("got %d updates, expected 1", len(updates))
+	}
+	if updates[0].Body["ts"] != ts || updates[0].Body["channel"] != "C0123" {
+		t.Errorf("updated the wrong message: %+v", updates[0].Body)
+	}
+	if text := updates[0].Body["text"]; !strings.Contains(text.(string), "Failure") {
+		t.Errorf("finished message says %q", text)
+	}
+
+	// The failed step's output and the attachment go in the thread
+	uploads := slack.callsTo("files.completeUploadExternal")
+	if len(uploads) != 2 {
+		t.Fatalf("got %d uploads, expected 2", len(uploads))
+	}
+	for _, upload := range uploads {
+		if upload.Body["thread_ts"] != ts || upload.Body["channel_id"] != "C0123" {
+			t.Errorf("upload wasn't shared in the thread: %+v", upload.Body)
+		}
+	}
+}
+
+func TestSlackRerunRepliesInThread(t *testing.T) {
+	slack := newFakeSlack()
+	defer slack.server.Close()
+	n := newTestSlackNotifier(slack)
+
+	// The build was already posted about when it first ran
+	threads := map[string]string{"C0123": "999.0001"}
+
+	started := slackTestEvent(EventStarted, threads)
+	started.Rerun = true
+	if err := n.Notify(started); err != nil {
+		t.Fatal(err)
+	}
+
+	finished := slackTestEvent(EventFinished, threads)
+	finished.Rerun = true
+	finished.Status = shared.StatusSuccess
+	if err := n.Notify(finished); err != nil {
+		t.Fatal(err)
+	}
+
+	updates := slack.callsTo("chat.update")
+	if len(updates) != 2 {
+		t.Fatalf("got %d updates, expected 2", len(updates))
+	}
+	for _, update := range updates {
+		if update.Body["ts"] != "999.0001" {
+			t.Errorf("updated the wrong message: %+v", update.Body)
+		}
+	}
+
+	replies := slack.callsTo("chat.postMessage")
+	if len(replies) != 2 {
+		t.Fatalf("got %d posts, expected 2 replies", len(replies))
+	}
+	for _, reply := range replies {
+		if reply.Body["thread_ts"] != "999.0001" {
+			t.Errorf("follow-up wasn't posted in the thread: %+v", reply.Body)
+		}
+	}
+	if text := replies[0].Body["text"].(string); !strings.Contains(text, "Rerun started") {
+		t.Errorf("first reply says %q", text)
+	}
+	if text := replies[1].Body["text"].(string); !strings.Contains(text, "Rerun finished") {
+		t.Errorf("second reply says %q", text)
+	}
+}
@@ -0,0 +1,233 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// The header carrying the signature of a webhook's body, as "sha256=" and
+// the hex HMAC-SHA256 of the body keyed with the webhook's secret
+const WebhookSignatureHeader = "X-Benkins-Signature-256"
+
+// The header naming the kind of event, like "finished"
+const WebhookEventHeader = "X-Benkins-Event"
+
+const webhookAttempts = 3
+
+// How long to wait before the first retry, which doubles after each one
+var webhookRetryBackoff = 1 * time.Second
+
+type WebhookConfig struct {
+	Url string `toml:"url"`
+
+	// Secret signs each payload, so the receiver can tell it came from
+	// Benkins. Payloads are not signed if it is empty.
+	Secret string `toml:"secret"`
+
+	// Events limits which kinds of event are sent. All of them are sent if
+	// it is empty.
+	Events []EventKind `toml:"events"`
+
+	// DeliveryLog is a file that every delivery attempt is appended to,
+	// one JSON object per line. Nothing is logged if it is empty.
+	DeliveryLog string `toml:"delivery_log"`
+}
+
+// WebhookPayload is the JSON body of every webhook. Queued events are sent
+// before the server knows anything about the commit, so they leave out the
+// commit message, status, runner, and commit URL:
+//
+//	{
+//	  "event": "finished",          // "queued", "started", or "finished"
+//	  "time": "2020-02-15T13:04:05Z",
+//	  "project": "frc-2175/robot",
+//	  "branch": "main",
+//	  "hash": "6d7cf38d6505a4209fc3cd045667c3c72f09f1a8",
+//	  "commit_message": "Tune the shooter",
+//	  "status": "success",          // "running" until the build finishes
+//	  "duration_seconds": 42.5,     // zero until the build finishes
+//	  "runner": "build-laptop",     // empty until the build starts
+//	  "url": "https://benkins.example.com/p/...",
+//	  "commit_url": "https://github.com/frc-2175/robot/commit/..."
+//	}
+type WebhookPayload struct {
+	Event           EventKind `json:"event"`
+	Time            time.Time `json:"time"`
+	Project         string    `json:"project"`
+	Branch          string    `json:"branch"`
+	Hash            string    `json:"hash"`
+	CommitMessage   string    `json:"commit_message,omitempty"`
+	Status          string    `json:"status,omitempty"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Runner          string    `json:"runner,omitempty"`
+	Url             string    `json:"url"`
+	CommitUrl       string    `json:"commit_url,omitempty"`
+}
+
+// WebhookNotifier POSTs a JSON payload about each event to a URL, retrying
+// a few times if the receiver fails.
+type WebhookNotifier struct {
+	Config WebhookConfig
+	Client *http.Client
+}
+
+func NewWebhookNotifier(config WebhookConfig) *WebhookNotifier {
+	return &WebhookNotifier{
+		Config: config,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (n *WebhookNotifier) Notify(event Event) error {
+	if !n.wants(event.Kind) {
+		return nil
+	}
+
+	body, err := json.Marshal(WebhookPayload{
+		Event:           event.Kind,
+		Time:            event.Time,
+		Project:         event.Project,
+		Branch:          event.Branch,
+		Hash:            event.Hash,
+		CommitMessage:   event.CommitMessage,
+		Status:          string(event.Status),
+		DurationSeconds: event.Duration.Seconds(),
+		Runner:          event.Runner,
+		Url:             event.Url,
+		CommitUrl:       event.CommitUrl,
+	})
+	if err != nil {
+		return err
+	}
+
+	backoff := webhookRetryBackoff
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		status, err := n.send(event.Kind, body)
+		n.logDelivery(webhookDelivery{
+			Time:     start,
+			Url:      n.Config.Url,
+			Event:    event.Kind,
+			Hash:     event.Hash,
+			Attempt:  attempt,
+			Status:   status,
+			Error:    errorString(err),
+			Duration: time.Since(start).Round(time.Millisecond).String(),
+		})
+
+		// The receiver won't accept the payload no matter how many times
+		// it's sent, so only retry server errors and failed connections
+		if err == nil || (400 <= status && status < 500) || attempt == webhookAttempts {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (n *WebhookNotifier) wants(kind EventKind) bool {
+	if len(n.Config.Events) == 0 {
+		return true
+	}
+
+	for _, wanted := range n.Config.Events {
+		if wanted == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// send makes one attempt at delivering the payload, returning the response's
+// status code if there was one.
+func (n *WebhookNotifier) send(kind EventKind, body []byte) (int, error) {
+	req, err := http.NewRequest("POST", n.Config.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, string(kind))
+	if n.Config.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, "sha256="+WebhookSignature(body, n.Config.Secret))
+	}
+
+	res, err := n.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || 299 < res.StatusCode {
+		resBody, _ := ioutil.ReadAll(res.Body)
+		return res.StatusCode, fmt.Errorf("webhook %s responded with status code %v: %s", n.Config.Url, res.StatusCode, resBody)
+	}
+
+	return res.StatusCode, nil
+}
+
+// WebhookSignature is the hex HMAC-SHA256 of body keyed with secret.
+// Receivers can compute it to check the signature header.
+func WebhookSignature(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type webhookDelivery struct {
+	Time     time.Time `json:"time"`
+	Url      string    `json:"url"`
+	Event    EventKind `json:"event"`
+	Hash     string    `json:"hash"`
+	Attempt  int       `json:"attempt"`
+	Status   int       `json:"status,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Duration string    `json:"duration"`
+}
+
+// Several jobs can deliver webhooks at once, so appends to delivery logs
+// take turns.
+var deliveryLogMutex sync.Mutex
+
+func (n *WebhookNotifier) logDelivery(delivery webhookDelivery) {
+	if n.Config.DeliveryLog == "" {
+		return
+	}
+
+	line, err := json.Marshal(delivery)
+	if err != nil {
+		return
+	}
+
+	deliveryLogMutex.Lock()
+	defer deliveryLogMutex.Unlock()
+
+	f, err := os.OpenFile(n.Config.DeliveryLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Printf("WARNING: failed to open webhook delivery log: %v\n", err)
+		return
+	}
+	defer f.Close()
+
+	f.Write(append(line, '\n'))
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
+}
@@ -0,0 +1,209 @@
+package notify
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/frc-2175/benkins/shared"
+)
+
+func init() {
+	// Don't make the retry tests wait for real
+	webhookRetryBackoff = time.Millisecond
+}
+
+var testEvent = Event{
+	Kind:     EventFinished,
+	Time:     time.Date(2020, 2, 15, 13, 4, 5, 0, time.UTC),
+	Project:  "frc-2175/robot",
+	Branch:   "main",
+	Hash:     "6d7cf38d6505a4209fc3cd045667c3c72f09f1a8",
+	Status:   shared.StatusSuccess,
+	Duration: 42 * time.Second,
+	Runner:   "build-laptop",
+}
+
+// webhookReceiver responds to each request with the next of statuses,
+// repeating the last one, and records the requests it gets.
+type webhookReceiver struct {
+	statuses []int
+
+	mutex    sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rec *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+
+	rec.requests = append(rec.requests, r)
+	rec.bodies = append(rec.bodies, body)
+
+	status := rec.statuses[len(rec.statuses)-1]
+	if len(rec.requests) <= len(rec.statuses) {
+		status = rec.statuses[len(rec.requests)-1]
+	}
+	w.WriteHeader(status)
+}
+
+// newTestWebhook creates a notifier for a receiver that responds with
+// statuses. The caller closes the receiver's server.
+func newTestWebhook(statuses ...int) (*WebhookNotifier, *webhookReceiver, *httptest.Server) {
+	rec := &webhookReceiver{statuses: statuses}
+	server := httptest.NewServer(rec)
+
+	return NewWebhookNotifier(WebhookConfig{Url: server.URL}), rec, server
+}
+
+func TestWebhookSignature(t *testing.T) {
+	n, rec, server := newTestWebhook(http.StatusOK)
+	defer server.Close()
+	n.Config.Secret = "s3cret"
+
+	if err := n.Notify(testEvent); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.requests) != 1 {
+		t.Fatalf("got %d requests, expected 1", len(rec.requests))
+	}
+
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(rec.bodies[0])
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if signature := rec.requests[0].Header.Get("X-Benkins-Signature-256"); signature != expected {
+		t.Errorf("got signature %q, expected %q", signature, expected)
+	}
+	if event := rec.requests[0].Header.Get("X-Benkins-Event"); event != "finished" {
+		t.Errorf("got event header %q, expected \"finished\"", event)
+	}
+
+	var payload WebhookPayload
+	if err := json.Unmarshal(rec.bodies[0], &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Hash != testEvent.Hash || payload.Status != "success" || payload.DurationSeconds != 42 {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+}
+
+func TestWebhookUnsigned(t *testing.T) {
+	n, rec, server := newTestWebhook(http.StatusOK)
+	defer server.Close()
+
+	if err := n.Notify(testEvent); err != nil {
+		t.Fatal(err)
+	}
+	if signature := rec.requests[0].Header.Get(WebhookSignatureHeader); signature != "" {
+		t.Errorf("payload with no secret was signed: %q", signature)
+	}
+}
+
+func TestWebhookRetriesServerErrors(t *testing.T) {
+	n, rec, server := newTestWebhook(http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK)
+	defer server.Close()
+
+	if err := n.Notify(testEvent); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.requests) != 3 {
+		t.Errorf("got %d requests, expected 3", len(rec.requests))
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	n, rec, server := newTestWebhook(http.StatusServiceUnavailable)
+	defer server.Close()
+
+	if err := n.Notify(testEvent); err == nil {
+		t.Error("expected an error")
+	}
+	if len(rec.requests) != webhookAttempts {
+		t.Errorf("got %d requests, expected %d", len(rec.requests), webhookAttempts)
+	}
+}
+
+func TestWebhookDoesNotRetryClientErrors(t *testing.T) {
+	n, rec, server := newTestWebhook(http.StatusBadRequest, http.StatusOK)
+	defer server.Close()
+
+	if err := n.Notify(testEvent); err == nil {
+		t.Error("expected an error")
+	}
+	if len(rec.requests) != 1 {
+		t.Errorf("got %d requests, expected 1", len(rec.requests))
+	}
+}
+
+func TestWebhookSkipsUnwantedEvents(t *testing.T) {
+	n, rec, server := newTestWebhook(http.StatusOK)
+	defer server.Close()
+	n.Config.Events = []EventKind{EventStarted}
+
+	if err := n.Notify(testEvent); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.requests) != 0 {
+		t.Errorf("got %d requests, expected none", len(rec.requests))
+	}
+}
+
+func TestWebhookDeliveryLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benkins-webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	n, _, server := newTestWebhook(http.StatusInternalServerError, http.StatusOK)
+	defer server.Close()
+	n.Config.DeliveryLog = filepath.Join(dir, "deliveries.jsonl")
+
+	if err := n.Notify(testEvent); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(n.Config.DeliveryLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var deliveries []webhookDelivery
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var delivery webhookDelivery
+		if err := json.Unmarshal(scanner.Bytes(), &delivery); err != nil {
+			t.Fatalf("bad delivery log line %q: %v", scanner.Text(), err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	if len(deliveries) != 2 {
+		t.Fatalf("got %d delivery log entries, expected 2", len(deliveries))
+	}
+	for i, delivery := range deliveries {
+		if delivery.Attempt != i+1 || delivery.Url != n.Config.Url || delivery.Event != EventFinished || delivery.Hash != testEvent.Hash {
+			t.Errorf("unexpected delivery log entry %+v", delivery)
+		}
+	}
+	if deliveries[0].Status != http.StatusInternalServerError || deliveries[0].Error == "" {
+		t.Errorf("first delivery should have failed with 500, got %+v", deliveries[0])
+	}
+	if deliveries[1].Status != http.StatusOK || deliveries[1].Error != "" {
+		t.Errorf("second delivery should have succeeded, got %+v", deliveries[1])
+	}
+}
@@ -1,15 +1,22 @@
 package main
 
 import (
+	"bufio"
+	"fmt"
 	"os"
+	"strings"
 
 	"github.com/frc-2175/benkins/server"
+	"github.com/frc-2175/benkins/shared"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
 func main() {
 	var basePath string
 	var password string
+	var masterKeyPath string
+	maxRetries := 2
 
 	if p, ok := os.LookupEnv("BENKINS_PASSWORD"); ok {
 		password = p
@@ -18,14 +25,125 @@ func main() {
 	cmd := &cobra.Command{
 		Use: "benkins-server",
 		Run: func(cmd *cobra.Command, args []string) {
-			server.Main(basePath, password)
+			server.Main(basePath, password, maxRetries, masterKeyPath)
 		},
 	}
 	cmd.Flags().StringVar(&basePath, "basePath", basePath, "The path to serve all files from")
+	cmd.Flags().StringVar(&masterKeyPath, "masterKeyFile", masterKeyPath, "The file holding the key secrets are encrypted with, outside of basePath; without it, jobs can't use secrets")
 	cmd.Flags().StringVar(&password, "Password", password, "The Password used for client authentication")
+	cmd.Flags().IntVar(&maxRetries, "maxRetries", maxRetries, "How many times to retry a job whose runner stops responding")
+
+	secretsCmd := &cobra.Command{
+		Use:   "secrets",
+		Short: "Manage the secrets jobs can use",
+	}
+	secretsCmd.PersistentFlags().StringVar(&basePath, "basePath", basePath, "The path the server serves all files from")
+	secretsCmd.PersistentFlags().StringVar(&masterKeyPath, "masterKeyFile", masterKeyPath, "The file holding the key secrets are encrypted with, outside of basePath; it is created if it doesn't exist")
+	must(secretsCmd.MarkPersistentFlagRequired("basePath"))
+	must(secretsCmd.MarkPersistentFlagRequired("masterKeyFile"))
+	secretsCmd.AddCommand(
+		&cobra.Command{
+			Use:   "set <project> <name>",
+			Short: "Set a secret, reading its value from stdin",
+			Args:  cobra.ExactArgs(2),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				if err := server.ValidateSecretName(args[1]); err != nil {
+					return err
+				}
+
+				value, err := readSecret(args[1])
+				if err != nil {
+					return err
+				}
+
+				store, err := server.NewSecretStore(basePath, masterKeyPath)
+				if err != nil {
+					return err
+				}
+
+				project := shared.NewProjectNameFromPlain(args[0])
+				if err := store.Set(project, args[1], value); err != nil {
+					return err
+				}
+				fmt.Printf("Set %s for %s.\n", args[1], project.Decoded())
+
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "list <project>",
+			Short: "List a project's secrets, without their values",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				store, err := server.NewSecretStore(basePath, masterKeyPath)
+				if err != nil {
+					return err
+				}
+
+				secrets, err := store.List(shared.NewProjectNameFromPlain(args[0]))
+				if err != nil {
+					return err
+				}
+
+				for _, secret := range secrets {
+					fmt.Printf("%s\t(updated %s)\n", secret.Name, secret.Updated.Format("2006-01-02 15:04"))
+				}
+
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "delete <project> <name>",
+			Short: "Delete a secret",
+			Args:  cobra.ExactArgs(2),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				store, err := server.NewSecretStore(basePath, masterKeyPath)
+				if err != nil {
+					return err
+				}
+
+				project := shared.NewProjectNameFromPlain(args[0])
+				deleted, err := store.Delete(project, args[1])
+				if err != nil {
+					return err
+				}
+				if !deleted {
+					return fmt.Errorf("%s has no secret named %s", project.Decoded(), args[1])
+				}
+				fmt.Printf("Deleted %s from %s.\n", args[1], project.Decoded())
+
+				return nil
+			},
+		},
+	)
+	cmd.AddCommand(secretsCmd)
 
 	err := cmd.Execute()
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+func must(err error) {
 	if err != nil {
 		panic(err)
 	}
 }
+
+// readSecret prompts for a secret without echoing it, or reads it from stdin
+// if stdin isn't a terminal.
+func readSecret(name string) (string, error) {
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Printf("Enter the value of %s: ", name)
+		value, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println()
+		return string(value), err
+	}
+
+	value, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && value == "" {
+		return "", err
+	}
+
+	return strings.TrimRight(value, "\r\n"), nil
+}
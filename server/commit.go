@@ -1,6 +1,7 @@
 package server
 
 import (
+	"bytes"
 	"io/ioutil"
 	"net/http"
 	"os"
@@ -16,7 +17,7 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func CommitIndex(r *gin.Engine, loader Loader) gin.HandlerFunc {
+func CommitIndex(r *gin.Engine, loader Loader, settings Settings) gin.HandlerFunc {
 	r.HTMLRender.(multitemplate.Renderer).AddFromFilesFuncs("commit", TemplateFuncs, "server/tmpl/base.html", "server/tmpl/commit.html")
 
 	return func(c *gin.Context) {
@@ -28,40 +29,52 @@ func CommitIndex(r *gin.Engine, loader Loader) gin.HandlerFunc {
 			return
 		}
 
+		// A job may not have sent any of its log, whether it is still
+		// running or its runner went away
 		logs, err := ioutil.ReadFile(filepath.Join(commit.Filepath, shared.ExecutionLogFilename))
-		if err != nil {
-			code := http.StatusInternalServerError
-			if os.IsNotExist(err) {
-				code = http.StatusNotFound
-			}
-
-			c.AbortWithError(code, err)
+		if err != nil && !os.IsNotExist(err) {
+			c.AbortWithError(http.StatusInternalServerError, err)
 			return
 		}
 
-		blocks := ansicolors.Process(logs)
+		// While the job is running, only show complete lines; the page
+		// picks up the rest from the live log stream.
+		if commit.Status == shared.StatusRunning {
+			logs = logs[:bytes.LastIndexByte(logs, '\n')+1]
+		}
 
-		var htmlBlocks []HTMLBlock
-		for _, b := range blocks {
-			var classes []string
-			for _, a := range b.Attributes {
-				if class, ok := Attribute2Class[a]; ok {
-					classes = append(classes, class)
-				}
-			}
+		c.HTML(http.StatusOK, "commit", v{
+			"projectName":    projectName,
+			"commit":         commit,
+			"forgeCommitUrl": settings.CommitUrl(projectName, commit.Hash),
+			"logBlocks":      LogHTMLBlocks(logs),
+			"logSize":        len(logs),
+			"running":        commit.Status == shared.StatusRunning,
+		})
+	}
+}
 
-			htmlBlocks = append(htmlBlocks, HTMLBlock{
-				Classes: strings.Join(classes, " "),
-				Text:    string(b.Contents),
-			})
+// LogHTMLBlocks converts a log's ANSI colors into blocks of text with CSS
+// classes.
+func LogHTMLBlocks(logs []byte) []HTMLBlock {
+	blocks := ansicolors.Process(logs)
+
+	var htmlBlocks []HTMLBlock
+	for _, b := range blocks {
+		var classes []string
+		for _, a := range b.Attributes {
+			if class, ok := Attribute2Class[a]; ok {
+				classes = append(classes, class)
+			}
 		}
 
-		c.HTML(http.StatusOK, "commit", v{
-			"projectName": projectName,
-			"commit":      commit,
-			"logBlocks":   htmlBlocks,
+		htmlBlocks = append(htmlBlocks, HTMLBlock{
+			Classes: strings.Join(classes, " "),
+			Text:    string(b.Contents),
 		})
 	}
+
+	return htmlBlocks
 }
 
 type HTMLBlock struct {
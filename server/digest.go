@@ -0,0 +1,186 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/frc-2175/benkins/notify"
+	"github.com/frc-2175/benkins/shared"
+)
+
+const defaultDigestSlowest = 3
+
+// DigestSettings sets up a daily email summarizing every project's builds.
+type DigestSettings struct {
+	SMTP notify.SMTPConfig `toml:"smtp"`
+	To   []string          `toml:"to"`
+
+	// Hour is the hour of the day, in the server's time zone, to send the
+	// digest at. Defaults to midnight.
+	Hour int `toml:"hour"`
+
+	// Slowest is how many of each project's slowest jobs to list.
+	// Defaults to 3.
+	Slowest int `toml:"slowest"`
+}
+
+// ProjectDigest summarizes a project's builds over some period.
+type ProjectDigest struct {
+	Name   shared.ProjectName
+	Passed int
+	Failed int
+
+	// The latest commit on each branch whose latest build failed, at any
+	// point in time
+	Broken []Commit
+
+	Slowest []Commit
+}
+
+// BuildDigest summarizes the builds of every project that finished after
+// since. Projects with nothing to report are left out.
+func BuildDigest(loader Loader, since time.Time, slowest int) ([]ProjectDigest, error) {
+	projects, err := loader.LoadProjects()
+	if err != nil {
+		return nil, err
+	}
+
+	var digests []ProjectDigest
+	for name, commits := range projects {
+		digest := ProjectDigest{Name: name}
+
+		var recent []Commit
+		for _, commit := range commits {
+			if commit.Status == shared.StatusRunning || commit.Time.Before(since) {
+				continue
+			}
+
+			recent = append(recent, commit)
+			if commit.Status == shared.StatusSuccess {
+				digest.Passed++
+			} else if notify.IsFailure(commit.Status) {
+				digest.Failed++
+			}
+		}
+
+		for _, branch := range loader.Branches(commits) {
+			for _, commit := range branch.Commits {
+				if commit.Status == shared.StatusRunning {
+					continue
+				}
+				if notify.IsFailure(commit.Status) {
+					digest.Broken = append(digest.Broken, commit)
+				}
+				break
+			}
+		}
+
+		sort.SliceStable(recent, func(i, j int) bool {
+			return recent[i].Duration > recent[j].Duration
+		})
+		if len(recent) > slowest {
+			recent = recent[:slowest]
+		}
+		digest.Slowest = recent
+
+		if digest.Passed+digest.Failed == 0 && len(digest.Broken) == 0 {
+			continue
+		}
+		digests = append(digests, digest)
+	}
+
+	sort.Slice(digests, func(i, j int) bool {
+		return digests[i].Name.Decoded() < digests[j].Name.Decoded()
+	})
+
+	return digests, nil
+}
+
+// DigestText writes out digests as the body of an email.
+func DigestText(digests []ProjectDigest, serverUrl string) string {
+	var b strings.Builder
+
+	for i, digest := range digests {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+
+		fmt.Fprintf(&b, "%s\n", digest.Name.Decoded())
+		fmt.Fprintf(&b, "%s\n", strings.Repeat("=", len(digest.Name.Decoded())))
+		fmt.Fprintf(&b, "%d passed, %d failed\n", digest.Passed, digest.Failed)
+
+		if len(digest.Broken) > 0 {
+			fmt.Fprintf(&b, "\nBroken branches:\n")
+			for _, commit := range digest.Broken {
+				fmt.Fprintf(&b, "  %s (%s, %s, %s ago)%s\n", commit.BranchName, Short(commit.Hash), commit.Status, time.Since(commit.Time).Truncate(time.Minute), digestLink(serverUrl, digest.Name, commit))
+			}
+		}
+
+		if len(digest.Slowest) > 0 {
+			fmt.Fprintf(&b, "\nSlowest jobs:\n")
+			for _, commit := range digest.Slowest {
+				fmt.Fprintf(&b, "  %v  %s (%s)%s\n", commit.Duration.Truncate(time.Second), commit.BranchName, Short(commit.Hash), digestLink(serverUrl, digest.Name, commit))
+			}
+		}
+	}
+
+	return b.String()
+}
+
+func digestLink(serverUrl string, projectName shared.ProjectName, commit Commit) string {
+	if serverUrl == "" {
+		return ""
+	}
+
+	return " " + strings.TrimSuffix(serverUrl, "/") + CommitUrl(projectName, commit.Hash)
+}
+
+// WatchDigest emails a digest of the last day's builds every day at the
+// configured hour, linking to results on the server at serverUrl.
+func WatchDigest(loader Loader, settings DigestSettings, serverUrl string) {
+	slowest := settings.Slowest
+	if slowest == 0 {
+		slowest = defaultDigestSlowest
+	}
+
+	for {
+		now := time.Now()
+		next := time.Date(now.Year(), now.Month(), now.Day(), settings.Hour, 0, 0, 0, now.Location())
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+		time.Sleep(time.Until(next))
+
+		func() {
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					fmt.Printf("PANIC RECOVERED: %v\n", recovered)
+				}
+			}()
+
+			sendDigest(loader, settings, serverUrl, next.AddDate(0, 0, -1), slowest)
+		}()
+	}
+}
+
+func sendDigest(loader Loader, settings DigestSettings, serverUrl string, since time.Time, slowest int) {
+	digests, err := BuildDigest(loader, since, slowest)
+	if err != nil {
+		fmt.Printf("ERROR: failed to build daily digest: %v\n", err)
+		return
+	}
+	if len(digests) == 0 {
+		fmt.Printf("No builds to report in the daily digest.\n")
+		return
+	}
+
+	subject := fmt.Sprintf("[Benkins] Daily digest for %s", time.Now().Format("Mon Jan 2"))
+	if err := notify.SendEmail(settings.SMTP, settings.To, subject, DigestText(digests, serverUrl)); err != nil {
+		fmt.Printf("ERROR: failed to send daily digest: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Sent the daily digest to %s.\n", strings.Join(settings.To, ", "))
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/frc-2175/benkins/shared"
+)
+
+type digestFixture struct {
+	project  string
+	hash     string
+	branch   string
+	status   shared.Status
+	age      time.Duration
+	duration time.Duration
+}
+
+// writeDigestFixtures lays out results in basePath the way the server saves
+// them. A commit's time is its folder's modification time.
+func writeDigestFixtures(t *testing.T, basePath string, now time.Time, fixtures []digestFixture) {
+	t.Helper()
+
+	for _, fixture := range fixtures {
+		dir := filepath.Join(basePath, artifactPath(shared.NewProjectNameFromPlain(fixture.project).Encoded(), fixture.hash))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		results := shared.JobResults{
+			Success:    fixture.status == shared.StatusSuccess,
+			Status:     fixture.status,
+			BranchName: fixture.branch,
+			Duration:   fixture.duration,
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, shared.ResultsFilename), []byte(results.ToTOML()), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		modTime := now.Add(-fixture.age)
+		if err := os.Chtimes(dir, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func digestHashes(commits []Commit) []string {
+	var hashes []string
+	for _, commit := range commits {
+		hashes = append(hashes, commit.Hash)
+	}
+
+	return hashes
+}
+
+func TestBuildDigest(t *testing.T) {
+	basePath, err := ioutil.TempDir("", "benkins-digest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(basePath)
+
+	now := time.Now()
+	day := 24 * time.Hour
+	writeDigestFixtures(t, basePath, now, []digestFixture{
+		{"frc-2175/robot", "aaaaaaa", "main", shared.StatusSuccess, 2 * time.Hour, 30 * time.Second},
+		{"frc-2175/robot", "bbbbbbb", "main", shared.StatusFailure, 1 * time.Hour, 90 * time.Second},
+		{"frc-2175/robot", "ccccccc", "feature", shared.StatusSuccess, 3 * time.Hour, 60 * time.Second},
+		{"frc-2175/robot", "ddddddd", "feature", shared.StatusRunning, 1 * time.Minute, 0},
+		{"frc-2175/robot", "eeeeeee", "old", shared.StatusErrored, 3 * day, 10 * time.Second},
+		{"frc-2175/robot", "fffffff", "release", shared.StatusTimedOut, 30 * time.Minute, 120 * time.Second},
+
+		// Nothing new and nothing broken, so it's left out
+		{"frc-2175/scouting", "1111111", "main", shared.StatusSuccess, 3 * day, 20 * time.Second},
+
+		{"frc-2175/dashboard", "2222222", "main", shared.StatusSuccess, 5 * time.Hour, 15 * time.Second},
+	})
+
+	digests, err := BuildDigest(NewLoader(basePath), now.Add(-day), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(digests) != 2 {
+		t.Fatalf("got %d project digests, expected 2: %+v", len(digests), digests)
+	}
+
+	dashboard, robot := digests[0], digests[1]
+	if dashboard.Name.Decoded() != "frc-2175/dashboard" || robot.Name.Decoded() != "frc-2175/robot" {
+		t.Fatalf("got projects %s and %s", dashboard.Name.Decoded(), robot.Name.Decoded())
+	}
+
+	if dashboard.Passed != 1 || dashboard.Failed != 0 || len(dashboard.Broken) != 0 {
+		t.Errorf("unexpected dashboard digest %+v", dashboard)
+	}
+
+	if robot.Passed != 2 || robot.Failed != 2 {
+		t.Errorf("got %d passed and %d failed, expected 2 and 2", robot.Passed, robot.Failed)
+	}
+
+	// The running build on feature doesn't hide that feature's latest
+	// finished build passed, and old is still broken however long ago it
+	// broke
+	if broken := strings.Join(digestHashes(robot.Broken), ","); broken != "fffffff,bbbbbbb,eeeeeee" {
+		t.Errorf("got broken branches at %s, expected fffffff,bbbbbbb,eeeeeee", broken)
+	}
+
+	if slowest := strings.Join(digestHashes(robot.Slowest), ","); slowest != "fffffff,bbbbbbb" {
+		t.Errorf("got slowest jobs %s, expected fffffff,bbbbbbb", slowest)
+	}
+
+	text := DigestText(digests, "https://benkins.example.com/")
+	for _, expected := range []string{
+		"frc-2175/robot\n==============\n2 passed, 2 failed\n",
+		"  release (fffffff, timed out, 30m0s ago) https://benkins.example.com" + CommitUrl(robot.Name, "fffffff") + "\n",
+		"  2m0s  release (fffffff)",
+	} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("digest is missing %q:\n%s", expected, text)
+		}
+	}
+}
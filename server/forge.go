@@ -0,0 +1,339 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/frc-2175/benkins/forge"
+	"github.com/frc-2175/benkins/shared"
+	"github.com/pelletier/go-toml"
+)
+
+// ForgeStateFilename is where the server keeps track of what it has told a
+// commit's forge about the commit's job, so that later results update the
+// same check run.
+const ForgeStateFilename = "benkins-forge.toml"
+
+type forgeReportRequest struct {
+	job QueuedJob
+
+	// If not empty, the job's run stopped without final results, and its
+	// check run is completed with this conclusion and title.
+	abandoned forge.CheckRunConclusion
+	reason    string
+}
+
+// Reports are sent to forges one at a time in the background, like
+// notifications, so that a slow forge doesn't hold up runners.
+var forgeReports = make(chan forgeReportRequest, 100)
+
+// queueForgeReport asks ReportToForges to show a job's latest results on
+// its commit's forge.
+func queueForgeReport(job QueuedJob) {
+	sendForgeReport(forgeReportRequest{job: job})
+}
+
+// queueForgeAbandoned asks ReportToForges to complete the check run of a
+// run of a job that will never send final results, because its runner shut
+// down or lost its lease. The job's commit status is left alone, since the
+// job will be run again.
+func queueForgeAbandoned(job QueuedJob, conclusion forge.CheckRunConclusion, reason string) {
+	sendForgeReport(forgeReportRequest{job: job, abandoned: conclusion, reason: reason})
+}
+
+// sendForgeReport queues a report without waiting. If the forges are so far
+// behind that the queue is full, the report is dropped, and the job's next
+// report catches the forge up.
+func sendForgeReport(request forgeReportRequest) {
+	select {
+	case forgeReports <- request:
+	default:
+		fmt.Printf("WARNING: too many forge reports are waiting to be sent, so a report for %s commit %s was dropped\n", request.job.Project.Decoded(), request.job.Hash)
+	}
+}
+
+type forgeState struct {
+	// The run of the job that the rest of the state is about
+	RunId string
+
+	// The last commit status that was set
+	State forge.CommitState
+
+	CheckRunId int64
+
+	// How many of the job's annotations the check run already has
+	SentAnnotations int
+
+	// Whether the check run has been completed
+	Completed bool
+}
+
+// ReportToForges reports queued jobs to the forges in their projects'
+// settings, as commit statuses or check runs, until the server stops.
+// Runners don't need forge tokens, since only the server talks to forges.
+func ReportToForges(loader Loader, settings Settings) {
+	for request := range forgeReports {
+		func() {
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					fmt.Printf("PANIC RECOVERED: %v\n", recovered)
+				}
+			}()
+
+			reportToForge(loader, settings, request)
+		}()
+	}
+}
+
+func reportToForge(loader Loader, settings Settings, request forgeReportRequest) {
+	job := request.job
+
+	project, ok := settings.Project(job.Project)
+	if !ok {
+		return
+	}
+
+	f, config := project.Forge()
+	if f == nil || config.Token == "" {
+		return
+	}
+
+	commit, err := loader.Commit(job.Project, job.Hash)
+	if err != nil {
+		fmt.Printf("WARNING: failed to load results to report to the forge: %v\n", err)
+		return
+	}
+
+	statePath := filepath.Join(commit.Filepath, ForgeStateFilename)
+
+	var state forgeState
+	if stateBytes, err := ioutil.ReadFile(statePath); err == nil {
+		if err := toml.Unmarshal(stateBytes, &state); err != nil {
+			fmt.Printf("WARNING: failed to read %s for %s commit %s: %v\n", ForgeStateFilename, job.Project.Decoded(), job.Hash, err)
+		}
+	}
+	if state.RunId != job.RunId {
+		state = forgeState{RunId: job.RunId}
+	}
+
+	report := forgeReport{
+		forge:  f,
+		job:    job,
+		commit: commit,
+		state:  &state,
+	}
+	if settings.Url != "" {
+		report.detailsUrl = strings.TrimSuffix(settings.Url, "/") + CommitUrl(job.Project, job.Hash)
+	}
+
+	checks, ok := f.(forge.CheckRunner)
+	switch {
+	case config.CheckRuns && ok && request.abandoned != "":
+		report.abandonCheckRun(checks, request.abandoned, request.reason)
+	case config.CheckRuns && ok:
+		report.checkRun(checks)
+	case request.abandoned == "":
+		report.status()
+	}
+
+	stateBytes, err := toml.Marshal(state)
+	if err == nil {
+		err = ioutil.WriteFile(statePath, stateBytes, 0644)
+	}
+	if err != nil {
+		fmt.Printf("WARNING: failed to save %s for %s commit %s: %v\n", ForgeStateFilename, job.Project.Decoded(), job.Hash, err)
+	}
+}
+
+type forgeReport struct {
+	forge      forge.Forge
+	job        QueuedJob
+	commit     Commit
+	state      *forgeState
+	detailsUrl string
+}
+
+// context is what the job's commit statuses are reported under.
+func (r forgeReport) context() string {
+	if r.commit.Name != "" {
+		return r.commit.Name
+	}
+
+	return "benkins"
+}
+
+// status sets the commit's status, if it has changed.
+func (r forgeReport) status() {
+	var state forge.CommitState
+	var description string
+	switch r.commit.Status {
+	case shared.StatusRunning:
+		state = forge.StatePending
+		description = fmt.Sprintf("Running on %s", r.job.Runner)
+	case shared.StatusSuccess:
+		state = forge.StateSuccess
+		description = fmt.Sprintf("Succeeded in %v", r.commit.Duration)
+	case shared.StatusTimedOut:
+		state = forge.StateFailure
+		description = fmt.Sprintf("Timed out after %v", r.commit.Duration)
+	case shared.StatusErrored:
+		state = forge.StateError
+		description = r.commit.Error
+	default:
+		state = forge.StateFailure
+		description = fmt.Sprintf("Failed after %v", r.commit.Duration)
+	}
+
+	if state == r.state.State && state == forge.StatePending {
+		return
+	}
+
+	err := r.forge.SetStatus(r.job.Hash, forge.Status{
+		State:       state,
+		TargetUrl:   r.detailsUrl,
+		Description: description,
+		Context:     r.context(),
+	})
+	if err != nil {
+		fmt.Printf("WARNING: failed to set commit status: %v\n", err)
+		return
+	}
+
+	r.state.State = state
+}
+
+// checkRun creates the job's check run if it doesn't have one yet, then
+// shows the steps that have finished so far, or the job's final results.
+// Annotations for the errors the steps printed are added as they come in.
+func (r forgeReport) checkRun(checks forge.CheckRunner) {
+	if r.state.Completed {
+		return
+	}
+
+	if r.state.CheckRunId == 0 {
+		now := time.Now()
+		id, err := checks.CreateCheckRun(forge.GitHubCheckRun{
+			Name:       r.context(),
+			HeadSha:    r.job.Hash,
+			DetailsUrl: r.detailsUrl,
+			Status:     forge.CheckRunInProgress,
+			StartedAt:  &now,
+			Output: &forge.GitHubCheckOutput{
+				Title:   fmt.Sprintf("Running on %s", r.job.Runner),
+				Summary: "Starting...",
+			},
+		})
+		if err != nil {
+			fmt.Printf("WARNING: failed to create check run: %v\n", err)
+			return
+		}
+		r.state.CheckRunId = id
+	}
+
+	if r.commit.Status == shared.StatusRunning {
+		title := fmt.Sprintf("Running step %d of %d", len(r.commit.Steps)+1, r.commit.TotalSteps)
+		if len(r.commit.Steps) >= r.commit.TotalSteps {
+			title = "Finishing up"
+		}
+
+		r.sendCheckRun(checks, forge.GitHubCheckRun{
+			Output: r.checkOutput(title),
+		})
+		return
+	}
+
+	var title string
+	conclusion := forge.ConclusionFailure
+	switch r.commit.Status {
+	case shared.StatusSuccess:
+		title = fmt.Sprintf("Succeeded in %v", r.commit.Duration)
+		conclusion = forge.ConclusionSuccess
+	case shared.StatusTimedOut:
+		title = fmt.Sprintf("Timed out after %v", r.commit.Duration)
+		conclusion = forge.ConclusionTimedOut
+	case shared.StatusErrored:
+		title = fmt.Sprintf("Errored: %s", r.commit.Error)
+	default:
+		title = fmt.Sprintf("Failed after %v", r.commit.Duration)
+	}
+
+	now := time.Now()
+	r.sendCheckRun(checks, forge.GitHubCheckRun{
+		Status:      forge.CheckRunCompleted,
+		Conclusion:  conclusion,
+		CompletedAt: &now,
+		Output:      r.checkOutput(title),
+	})
+}
+
+// checkOutput summarizes the steps and attaches the annotations the check
+// run doesn't have yet.
+func (r forgeReport) checkOutput(title string) *forge.GitHubCheckOutput {
+	var summary strings.Builder
+	for _, step := range r.commit.Steps {
+		fmt.Fprintf(&summary, "- **%s**: %s", step.Name, step.Status)
+		if step.Status != shared.StatusSkipped {
+			fmt.Fprintf(&summary, " in %v", step.Duration)
+		}
+		summary.WriteString("\n")
+	}
+	if summary.Len() == 0 {
+		summary.WriteString("No steps have finished yet.")
+	}
+
+	var annotations []forge.GitHubCheckAnnotation
+	for _, step := range r.commit.Steps {
+		for _, annotation := range step.Annotations {
+			annotations = append(annotations, forge.GitHubCheckAnnotation{
+				Path:            annotation.Path,
+				StartLine:       annotation.Line,
+				EndLine:         annotation.Line,
+				AnnotationLevel: string(annotation.Level),
+				Message:         annotation.Message,
+			})
+		}
+	}
+	if r.state.SentAnnotations < len(annotations) {
+		annotations = annotations[r.state.SentAnnotations:]
+	} else {
+		annotations = nil
+	}
+
+	return &forge.GitHubCheckOutput{
+		Title:       title,
+		Summary:     summary.String(),
+		Annotations: annotations,
+	}
+}
+
+// abandonCheckRun completes the check run of a run that stopped early, if it
+// has one.
+func (r forgeReport) abandonCheckRun(checks forge.CheckRunner, conclusion forge.CheckRunConclusion, reason string) {
+	if r.state.CheckRunId == 0 || r.state.Completed {
+		return
+	}
+
+	now := time.Now()
+	r.sendCheckRun(checks, forge.GitHubCheckRun{
+		Status:      forge.CheckRunCompleted,
+		Conclusion:  conclusion,
+		CompletedAt: &now,
+		Output:      r.checkOutput(reason),
+	})
+}
+
+func (r forgeReport) sendCheckRun(checks forge.CheckRunner, run forge.GitHubCheckRun) {
+	sent, err := checks.UpdateCheckRun(r.state.CheckRunId, run)
+	r.state.SentAnnotations += sent
+	if err != nil {
+		fmt.Printf("WARNING: failed to update check run: %v\n", err)
+		return
+	}
+
+	if run.Status == forge.CheckRunCompleted {
+		r.state.Completed = true
+	}
+}
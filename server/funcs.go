@@ -4,16 +4,20 @@ import (
 	"fmt"
 	"html/template"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/frc-2175/benkins/shared"
 )
 
 var TemplateFuncs = template.FuncMap{
-	"projectUrl": ProjectUrl,
-	"commitUrl":  CommitUrl,
-	"fileUrl":    FileUrl,
-	"short":      Short,
+	"projectUrl":  ProjectUrl,
+	"commitUrl":   CommitUrl,
+	"fileUrl":     FileUrl,
+	"short":       Short,
+	"statusEmoji": StatusEmoji,
+	"statusText":  StatusText,
+	"join":        strings.Join,
 
 	"now":        time.Now,
 	"timeSecond": func() time.Duration { return time.Second },
@@ -34,3 +38,41 @@ func FileUrl(projectName shared.ProjectName, hash, filename string) string {
 func Short(hash string) string {
 	return hash[0:7]
 }
+
+func StatusEmoji(status shared.Status) string {
+	switch status {
+	case shared.StatusSuccess:
+		return "✅"
+	case shared.StatusFailure:
+		return "❌"
+	case shared.StatusSkipped:
+		return "⏭️"
+	case shared.StatusTimedOut:
+		return "⏱️"
+	case shared.StatusRunning:
+		return "🟡"
+	case shared.StatusErrored:
+		return "⚠️"
+	}
+
+	return "❔"
+}
+
+func StatusText(status shared.Status) string {
+	switch status {
+	case shared.StatusSuccess:
+		return "Success"
+	case shared.StatusFailure:
+		return "Failure"
+	case shared.StatusSkipped:
+		return "Skipped"
+	case shared.StatusTimedOut:
+		return "Timed out"
+	case shared.StatusRunning:
+		return "Running"
+	case shared.StatusErrored:
+		return "Errored"
+	}
+
+	return string(status)
+}
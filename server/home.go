@@ -7,7 +7,16 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func Home(r *gin.Engine, loader Loader) gin.HandlerFunc {
+// QueueEntry is a job in the queue as shown on the home page.
+type QueueEntry struct {
+	QueuedJob
+
+	// Whether the job is stuck because no online runner has the labels
+	// it needs
+	NoRunner bool
+}
+
+func Home(r *gin.Engine, loader Loader, queue *Queue) gin.HandlerFunc {
 	r.HTMLRender.(multitemplate.Renderer).AddFromFilesFuncs("home", TemplateFuncs, "server/tmpl/base.html", "server/tmpl/home.html")
 
 	return func(c *gin.Context) {
@@ -17,9 +26,20 @@ func Home(r *gin.Engine, loader Loader) gin.HandlerFunc {
 			return
 		}
 
+		runners := Runners()
+
+		var queueEntries []QueueEntry
+		for _, job := range queue.Jobs() {
+			queueEntries = append(queueEntries, QueueEntry{
+				QueuedJob: job,
+				NoRunner:  job.State == JobQueued && len(job.RunsOn) > 0 && !AnyRunnerCanRun(runners, job.RunsOn),
+			})
+		}
+
 		c.HTML(http.StatusOK, "home", v{
-			"projects":   projects,
-			"heartbeats": heartbeats,
+			"projects": projects,
+			"runners":  runners,
+			"queue":    queueEntries,
 		})
 	}
 }
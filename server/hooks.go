@@ -0,0 +1,75 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/frc-2175/benkins/forge"
+	"github.com/frc-2175/benkins/shared"
+	"github.com/gin-gonic/gin"
+)
+
+// Webhook queues a job for the head of every branch pushed to a repo, so
+// that builds start without waiting for runners to poll. The :forge param
+// says which kind of forge sent the webhook, like "github" or "gitea". The
+// request must be a push event signed with the webhook secret of the
+// project whose repo matches the payload's repository.full_name. Anything
+// that can send such a request can trigger builds, so a signed canned
+// payload works as well as the forge itself.
+func Webhook(loader Loader, queue *Queue, settings Settings) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		webhooks, ok := forge.WebhooksFor(c.Param("forge"))
+		if !ok {
+			c.String(http.StatusNotFound, "Unknown forge %s.", c.Param("forge"))
+			return
+		}
+
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		event, err := webhooks.Parse(c.Request.Header, body)
+		if err != nil {
+			c.String(http.StatusBadRequest, "invalid payload: %v", err)
+			return
+		}
+
+		project, ok := settings.ProjectForRepo(event.Repo)
+		if !ok || project.WebhookSecret == "" {
+			c.String(http.StatusNotFound, "No project is set up for webhooks from %s.", event.Repo)
+			return
+		}
+
+		if !webhooks.Verify(c.Request.Header, body, project.WebhookSecret) {
+			c.String(http.StatusUnauthorized, "Invalid signature.")
+			return
+		}
+
+		switch event.Event {
+		case "ping":
+			c.String(http.StatusOK, "pong")
+			return
+		case "push":
+		default:
+			c.String(http.StatusOK, "Ignoring %s event.", event.Event)
+			return
+		}
+
+		if event.Hash == "" {
+			c.String(http.StatusOK, "Nothing to build.")
+			return
+		}
+
+		projectName := shared.NewProjectNameFromPlain(project.Name)
+		if !queueCommit(loader, queue, projectName, event.Hash, event.Branch, false) {
+			c.String(http.StatusOK, "This commit has already been run or queued.")
+			return
+		}
+
+		fmt.Printf("Queued %s branch %s (commit %s) from a webhook.\n", project.Name, event.Branch, event.Hash)
+		c.String(http.StatusCreated, "Queued.")
+	}
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/frc-2175/benkins/forge"
+	"github.com/frc-2175/benkins/notify"
+	"github.com/frc-2175/benkins/shared"
+	"github.com/gin-gonic/gin"
+	"github.com/pelletier/go-toml"
+)
+
+// EnqueueJob queues a job for the commit in the form values project (encoded)
+// and hash. Commits that have already been built are skipped unless rerun is
+// "true". Responds with 201 if a new job was queued.
+func EnqueueJob(loader Loader, queue *Queue) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		projectName := shared.NewProjectNameFromEncoded(c.PostForm("project"))
+		hash := c.PostForm("hash")
+		branch := c.PostForm("branch")
+		rerun := c.PostForm("rerun") == "true"
+
+		if projectName == "" || hash == "" {
+			c.String(http.StatusBadRequest, "project and hash are required")
+			return
+		}
+
+		if !queueCommit(loader, queue, projectName, hash, branch, rerun) {
+			c.String(http.StatusOK, "This commit has already been run or queued.")
+			return
+		}
+
+		c.String(http.StatusCreated, "Queued.")
+	}
+}
+
+// queueCommit queues a job for a commit unless the commit has already been
+// run or is already queued. It returns whether a new job was queued.
+func queueCommit(loader Loader, queue *Queue, projectName shared.ProjectName, hash, branch string, rerun bool) bool {
+	if !rerun && commitFinished(loader, projectName, hash) {
+		return false
+	}
+
+	if branch == "" {
+		if commit, err := loader.Commit(projectName, hash); err == nil {
+			branch = commit.BranchName
+		}
+	}
+
+	job, created := queue.Enqueue(projectName, hash, branch, rerun)
+	if created {
+		queueNotification(notify.EventQueued, job)
+	}
+
+	return created
+}
+
+// ClaimJob leases the oldest queued job for one of the projects in the form
+// values to the runner named in the form values, skipping jobs that need
+// labels the runner doesn't have. Responds with 204 if there is nothing to
+// do.
+func ClaimJob(queue *Queue) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		runner := c.PostForm("runner")
+		if runner == "" {
+			c.String(http.StatusBadRequest, "runner is required")
+			return
+		}
+
+		var projects []shared.ProjectName
+		for _, project := range c.PostFormArray("project") {
+			projects = append(projects, shared.NewProjectNameFromEncoded(project))
+		}
+
+		job, ok := queue.Claim(runner, projects, c.PostFormArray("label"))
+		if !ok {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.JSON(http.StatusOK, shared.ClaimedJob{
+			Project:      job.Project,
+			Hash:         job.Hash,
+			Branch:       job.Branch,
+			Rerun:        job.Rerun,
+			Attempt:      job.Attempts,
+			RunId:        job.RunId,
+			Lease:        job.Lease,
+			LeaseExpires: job.LeaseExpires,
+		})
+	}
+}
+
+// ReleaseJob puts a job back in the queue when the runner that claimed it
+// finds that the commit's benkins.toml asks for labels the runner doesn't
+// have, or when the runner shuts down in the middle of the job. The form
+// values are project (encoded), hash, and runs-on.
+func ReleaseJob(queue *Queue) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		projectName := shared.NewProjectNameFromEncoded(c.PostForm("project"))
+		hash := c.PostForm("hash")
+
+		job, ok := queue.Release(projectName, hash, c.GetHeader(shared.LeaseHeader), c.PostFormArray("runs-on"))
+		if !ok {
+			c.String(http.StatusGone, "This runner does not hold the lease on this commit's job.")
+			return
+		}
+		if job.Started {
+			queueForgeAbandoned(job, forge.ConclusionCancelled, fmt.Sprintf("Cancelled because %s shut down", job.Runner))
+		}
+
+		c.String(http.StatusOK, "Released.")
+	}
+}
+
+// RequireLease turns away uploads from runners that don't hold the lease on
+// the commit's job. They get 410 Gone, which tells a runner whose lease
+// expired that it should stop working on the job.
+func RequireLease(queue *Queue) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		projectName := shared.NewProjectNameFromEncoded(c.Param("project"))
+
+		if !queue.CheckLease(projectName, c.Param("hash"), c.GetHeader(shared.LeaseHeader)) {
+			c.String(http.StatusGone, "This runner does not hold the lease on this commit's job.")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// How often the server checks for jobs whose runners have gone away
+const leaseCheckInterval = 15 * time.Second
+
+// WatchLeases takes back the jobs of runners that stop checking in, such as a
+// laptop that went to sleep or a runner that crashed. Each such job is marked
+// as errored, then queued again unless it has already been retried
+// maxRetries times.
+func WatchLeases(loader Loader, queue *Queue, maxRetries int) {
+	for {
+		time.Sleep(leaseCheckInterval)
+
+		for _, job := range queue.Expire(maxRetries) {
+			retrying := job.CanRetry(maxRetries)
+			if retrying {
+				fmt.Printf("Runner %s stopped responding while running %s commit %s; queueing it again (attempt %d of %d).\n", job.Runner, job.Project.Decoded(), job.Hash, job.Attempts+1, maxRetries+1)
+			} else {
+				fmt.Printf("Runner %s stopped responding while running %s commit %s; giving up after %d attempts.\n", job.Runner, job.Project.Decoded(), job.Hash, job.Attempts)
+			}
+
+			if err := markRunnerLost(loader, job); err != nil {
+				fmt.Printf("WARNING: failed to record that %s commit %s errored: %v\n", job.Project.Decoded(), job.Hash, err)
+				continue
+			}
+			if retrying {
+				queueForgeAbandoned(job, forge.ConclusionNeutral, fmt.Sprintf("%s stopped responding, so the job will be run again", job.Runner))
+			} else {
+				queueForgeReport(job)
+				queueNotification(notify.EventFinished, job)
+			}
+		}
+	}
+}
+
+// markRunnerLost records in a commit's results that its runner went away
+// partway through the job, keeping whatever the runner had already reported.
+func markRunnerLost(loader Loader, job QueuedJob) error {
+	dir := filepath.Join(loader.BasePath, artifactPath(job.Project.Encoded(), job.Hash))
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	results := shared.JobResults{
+		BranchName: job.Branch,
+	}
+
+	resultsPath := filepath.Join(dir, shared.ResultsFilename)
+	if resultsBytes, err := ioutil.ReadFile(resultsPath); err == nil {
+		err = toml.Unmarshal(resultsBytes, &results)
+		if err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	results.Success = false
+	results.Status = shared.StatusErrored
+	results.Error = "runner lost"
+
+	// The runner may have died before sending any of its log, so say what
+	// happened there too
+	logFile, err := os.OpenFile(filepath.Join(dir, shared.ExecutionLogFilename), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(logFile, "\nERROR: runner %s stopped responding, so the job was given up on.\n", job.Runner)
+	if closeErr := logFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(resultsPath, []byte(results.ToTOML()), 0644)
+}
+
+// commitFinished reports whether the server has results for a commit. A
+// job that is still running has not finished, so if its runner went away it
+// should be run again.
+func commitFinished(loader Loader, projectName shared.ProjectName, hash string) bool {
+	_, err := os.Stat(filepath.Join(loader.BasePath, artifactPath(projectName.Encoded(), hash)))
+	if os.IsNotExist(err) {
+		return false
+	}
+
+	commit, err := loader.Commit(projectName, hash)
+	return !(err == nil && commit.Status == shared.StatusRunning)
+}
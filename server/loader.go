@@ -13,13 +13,24 @@ import (
 )
 
 type Commit struct {
-	Hash       string
-	BranchName string
-	Message    string
-	Time       time.Time
-	Success    bool
-	Filepath   string
-	Files      []string
+	Hash        string
+	BranchName  string
+	Message     string
+	Author      string
+	AuthorEmail string
+	Name        string
+	Time        time.Time
+	Success     bool
+	Status      shared.Status
+	Error       string
+	Duration    time.Duration
+	Steps       []shared.StepResult
+	TotalSteps  int
+	Filepath    string
+	Files       []string
+
+	Notification    string
+	NotifyArtifacts []string
 }
 
 type Branch struct {
@@ -107,6 +118,15 @@ func (l *Loader) Commit(projectName shared.ProjectName, hash string) (Commit, er
 		return Commit{}, fmt.Errorf("failed to decode %s for %s commit %s: %v", shared.ResultsFilename, projectName.Decoded(), hash, err)
 	}
 
+	status := results.Status
+	if status == "" {
+		// Results from older runners only record success or failure
+		status = shared.StatusFailure
+		if results.Success {
+			status = shared.StatusSuccess
+		}
+	}
+
 	fileInfos, err := ioutil.ReadDir(folderPath)
 	if err != nil {
 		return Commit{}, err
@@ -118,13 +138,24 @@ func (l *Loader) Commit(projectName shared.ProjectName, hash string) (Commit, er
 	}
 
 	return Commit{
-		Hash:       hash,
-		BranchName: results.BranchName,
-		Message:    results.CommitMessage,
-		Time:       info.ModTime(),
-		Success:    results.Success,
-		Filepath:   filepath.Join(l.BasePath, projectName.Encoded(), hash),
-		Files:      files,
+		Hash:        hash,
+		BranchName:  results.BranchName,
+		Message:     results.CommitMessage,
+		Author:      results.Author,
+		AuthorEmail: results.AuthorEmail,
+		Name:        results.Name,
+		Time:        info.ModTime(),
+		Success:     results.Success,
+		Status:      status,
+		Error:       results.Error,
+		Duration:    results.Duration,
+		Steps:       results.Steps,
+		TotalSteps:  results.TotalSteps,
+		Filepath:    filepath.Join(l.BasePath, projectName.Encoded(), hash),
+		Files:       files,
+
+		Notification:    results.Notification,
+		NotifyArtifacts: results.NotifyArtifacts,
 	}, nil
 }
 
@@ -157,3 +188,24 @@ func (l *Loader) Branches(commits []Commit) []Branch {
 
 	return result
 }
+
+// PreviousCommit finds the latest finished build on branch other than the
+// one for hash, if there is one.
+func (l *Loader) PreviousCommit(projectName shared.ProjectName, branch, hash string) (Commit, bool, error) {
+	commits, err := l.ProjectCommits(projectName)
+	if os.IsNotExist(err) {
+		return Commit{}, false, nil
+	} else if err != nil {
+		return Commit{}, false, err
+	}
+
+	for _, commit := range commits {
+		if commit.BranchName != branch || commit.Hash == hash || commit.Status == shared.StatusRunning {
+			continue
+		}
+
+		return commit, true, nil
+	}
+
+	return Commit{}, false, nil
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/frc-2175/benkins/shared"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+var errLogOffset = errors.New("log chunk does not start at the end of the log")
+
+var logMutex sync.Mutex
+
+// AppendLog appends a chunk of a running job's log to the log file at path,
+// returning the new size of the log. offset is where the chunk starts in the
+// full log, which makes retries safe: any part of the chunk the server
+// already has is skipped. A chunk at offset 0 starts the log over. If offset
+// is past the end of the log, errLogOffset is returned along with the current
+// size so the runner can resend from there.
+func AppendLog(path string, offset int64, chunk []byte) (int64, error) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+
+	if offset > size {
+		return size, errLogOffset
+	}
+
+	if skip := size - offset; skip < int64(len(chunk)) {
+		n, err := f.WriteAt(chunk[skip:], size)
+		size += int64(n)
+		if err != nil {
+			return size, err
+		}
+	}
+
+	return size, nil
+}
+
+const logStreamPollInterval = 500 * time.Millisecond
+
+var logSpansTemplate = template.Must(template.New("logSpans").Parse(`{{range .}}<span class="{{.Classes}}">{{.Text}}</span>{{end}}`))
+
+type logStreamEvent struct {
+	HTML   string `json:"html"`
+	Offset int64  `json:"offset"`
+}
+
+// LogStream sends a running job's log to the browser as Server-Sent Events,
+// starting from the offset query parameter. Each "log" event carries newly
+// received lines rendered the same way as the commit page, and has the
+// offset of the end of those lines as its ID. When the browser reconnects
+// by itself, it sends that ID back as Last-Event-ID, which takes the place
+// of the offset it started with so no lines are sent twice. A "done" event
+// is sent once the job has finished and the whole log has been sent.
+func LogStream(r *gin.Engine, loader Loader) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		projectName := shared.NewProjectNameFromEncoded(c.Param("project"))
+		hash := c.Param("hash")
+
+		offset, err := strconv.ParseInt(c.Query("offset"), 10, 64)
+		if err != nil || offset < 0 {
+			offset = 0
+		}
+		if lastId, err := strconv.ParseInt(c.GetHeader("Last-Event-ID"), 10, 64); err == nil && lastId >= 0 {
+			offset = lastId
+		}
+
+		c.Header("Cache-Control", "no-cache")
+
+		c.Stream(func(w io.Writer) bool {
+			commit, err := loader.Commit(projectName, hash)
+			if err != nil {
+				c.SSEvent("error", err.Error())
+				return false
+			}
+			running := commit.Status == shared.StatusRunning
+
+			chunk, err := readLogFrom(filepath.Join(commit.Filepath, shared.ExecutionLogFilename), offset)
+			if err != nil && !os.IsNotExist(err) {
+				c.SSEvent("error", err.Error())
+				return false
+			}
+
+			// Partial lines may end in the middle of an escape code, so
+			// wait for the rest of the line unless the job is done.
+			if running {
+				chunk = chunk[:bytes.LastIndexByte(chunk, '\n')+1]
+			}
+
+			if len(chunk) > 0 {
+				offset += int64(len(chunk))
+
+				var html bytes.Buffer
+				logSpansTemplate.Execute(&html, LogHTMLBlocks(chunk))
+				c.Render(-1, sse.Event{
+					Id:    strconv.FormatInt(offset, 10),
+					Event: "log",
+					Data: logStreamEvent{
+						HTML:   html.String(),
+						Offset: offset,
+					},
+				})
+			}
+
+			if !running {
+				c.SSEvent("done", string(commit.Status))
+				return false
+			}
+
+			select {
+			case <-time.After(logStreamPollInterval):
+			case <-c.Request.Context().Done():
+			}
+
+			return true
+		})
+	}
+}
+
+func readLogFrom(path string, offset int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(f)
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/frc-2175/benkins/ansicolors"
+	"github.com/frc-2175/benkins/notify"
+	"github.com/frc-2175/benkins/shared"
+	"github.com/pelletier/go-toml"
+)
+
+// NotificationsFilename is where the server keeps track of the messages it
+// has sent about a commit, so that later ones can refer to them.
+const NotificationsFilename = "benkins-notifications.toml"
+
+// Artifacts bigger than this are too big to attach to notifications
+const maxNotifyArtifactSize = 1 << 20
+
+type notification struct {
+	kind notify.EventKind
+	job  QueuedJob
+	time time.Time
+}
+
+// Notifications are sent one at a time in the background, so that slow
+// notifiers don't hold up runners and messages about a commit stay in order.
+var notifications = make(chan notification, 100)
+
+// queueNotification asks SendNotifications to tell people that something
+// happened to a job. If the notifiers are so far behind that the queue is
+// full, the notification is dropped rather than holding up the caller.
+func queueNotification(kind notify.EventKind, job QueuedJob) {
+	select {
+	case notifications <- notification{kind: kind, job: job, time: time.Now()}:
+	default:
+		fmt.Printf("WARNING: too many notifications are waiting to be sent, so the %s notification for %s commit %s was dropped\n", kind, job.Project.Decoded(), job.Hash)
+	}
+}
+
+type notificationState struct {
+	SlackThreads map[string]string
+}
+
+// SendNotifications sends queued notifications to the notifiers in settings
+// until the server stops.
+func SendNotifications(loader Loader, settings Settings) {
+	for n := range notifications {
+		func() {
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					fmt.Printf("PANIC RECOVERED: %v\n", recovered)
+				}
+			}()
+
+			sendNotification(loader, settings, n)
+		}()
+	}
+}
+
+func sendNotification(loader Loader, settings Settings, n notification) {
+	notifiers := settings.Notifiers(n.job.Project)
+	if len(notifiers) == 0 {
+		return
+	}
+
+	event := notificationEvent(loader, settings, n)
+
+	dir := filepath.Join(loader.BasePath, artifactPath(n.job.Project.Encoded(), n.job.Hash))
+	statePath := filepath.Join(dir, NotificationsFilename)
+
+	state := notificationState{SlackThreads: map[string]string{}}
+	if stateBytes, err := ioutil.ReadFile(statePath); err == nil {
+		if err := toml.Unmarshal(stateBytes, &state); err != nil {
+			fmt.Printf("WARNING: failed to read %s for %s commit %s: %v\n", NotificationsFilename, n.job.Project.Decoded(), n.job.Hash, err)
+		}
+		if state.SlackThreads == nil {
+			state.SlackThreads = map[string]string{}
+		}
+	}
+	event.SlackThreads = state.SlackThreads
+
+	sent := 0
+	for _, notifier := range notifiers {
+		if err := notifier.Notify(event); err != nil {
+			fmt.Printf("ERROR sending %s notification for %s commit %s: %v\n", n.kind, n.job.Project.Decoded(), n.job.Hash, err)
+			continue
+		}
+		sent++
+	}
+	fmt.Printf("Sent %d of %d %s notifications for %s commit %s.\n", sent, len(notifiers), n.kind, n.job.Project.Decoded(), n.job.Hash)
+
+	if len(state.SlackThreads) > 0 {
+		stateBytes, err := toml.Marshal(state)
+		if err == nil {
+			err = os.MkdirAll(dir, 0755)
+		}
+		if err == nil {
+			err = ioutil.WriteFile(statePath, stateBytes, 0644)
+		}
+		if err != nil {
+			fmt.Printf("WARNING: failed to save %s for %s commit %s: %v\n", NotificationsFilename, n.job.Project.Decoded(), n.job.Hash, err)
+		}
+	}
+}
+
+// notificationEvent describes a job for notifiers, using whatever results
+// the runner has uploaded so far.
+func notificationEvent(loader Loader, settings Settings, n notification) notify.Event {
+	event := notify.Event{
+		Kind:    n.kind,
+		Time:    n.time,
+		Project: n.job.Project.Decoded(),
+		Branch:  n.job.Branch,
+		Hash:    n.job.Hash,
+		Rerun:   n.job.Rerun,
+		Url:     strings.TrimSuffix(settings.Url, "/") + CommitUrl(n.job.Project, n.job.Hash),
+	}
+	if n.kind == notify.EventQueued {
+		return event
+	}
+
+	event.Runner = n.job.Runner
+	event.Status = shared.StatusRunning
+
+	commit, err := loader.Commit(n.job.Project, n.job.Hash)
+	if err != nil {
+		fmt.Printf("WARNING: failed to load results for %s notification: %v\n", n.kind, err)
+		return event
+	}
+
+	if event.Branch == "" {
+		event.Branch = commit.BranchName
+	}
+	event.CommitMessage = commit.Message
+	event.Author = commit.Author
+	event.AuthorEmail = commit.AuthorEmail
+	event.CommitUrl = settings.CommitUrl(n.job.Project, n.job.Hash)
+	if n.kind != notify.EventFinished {
+		return event
+	}
+
+	event.Status = commit.Status
+	event.Duration = commit.Duration
+	event.Text = commit.Notification
+
+	if previous, ok, err := loader.PreviousCommit(n.job.Project, event.Branch, n.job.Hash); err != nil {
+		fmt.Printf("WARNING: failed to find the previous result on %s: %v\n", event.Branch, err)
+	} else if ok {
+		event.PreviousStatus = previous.Status
+	}
+
+	for i, step := range commit.Steps {
+		if notify.IsFailure(step.Status) && !step.ContinueOnError {
+			event.FailedStep = step.Name
+			if log, err := ioutil.ReadFile(filepath.Join(commit.Filepath, shared.ExecutionLogFilename)); err == nil {
+				event.FailedStepOutput = stepOutput(log, i, len(commit.Steps))
+			}
+			break
+		}
+	}
+
+	for _, name := range commit.NotifyArtifacts {
+		artifactPath := filepath.Join(commit.Filepath, filepath.Base(name))
+
+		info, err := os.Stat(artifactPath)
+		if err != nil {
+			fmt.Printf("WARNING: failed to find artifact %s to attach to notifications: %v\n", name, err)
+			continue
+		}
+		if info.Size() > maxNotifyArtifactSize {
+			fmt.Printf("WARNING: artifact %s is too big to attach to notifications\n", name)
+			continue
+		}
+
+		content, err := ioutil.ReadFile(artifactPath)
+		if err != nil {
+			fmt.Printf("WARNING: failed to read artifact %s to attach to notifications: %v\n", name, err)
+			continue
+		}
+
+		event.Attachments = append(event.Attachments, notify.Attachment{
+			Name:    filepath.Base(name),
+			Content: content,
+		})
+	}
+
+	return event
+}
+
+// stepOutput finds what a step printed in a job's log, without colors. The
+// runner marks where each step starts with "==> Step 1/2: name" and where
+// it ends with "<== Step name ...".
+func stepOutput(log []byte, index, total int) string {
+	header := fmt.Sprintf("==> Step %d/%d: ", index+1, total)
+
+	var output strings.Builder
+	inStep := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(ansicolors.Strip(log)))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !inStep {
+			inStep = strings.HasPrefix(line, header)
+			continue
+		}
+
+		if strings.HasPrefix(line, "<== Step ") {
+			break
+		}
+		output.WriteString(line)
+		output.WriteString("\n")
+	}
+
+	return output.String()
+}
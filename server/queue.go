@@ -0,0 +1,359 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/frc-2175/benkins/shared"
+	"github.com/pelletier/go-toml"
+)
+
+// How long a runner holds a job without checking in. Runners renew their
+// leases with every heartbeat.
+const LeaseDuration = 3 * time.Minute
+
+type JobState string
+
+const (
+	JobQueued  JobState = "queued"
+	JobRunning JobState = "running"
+)
+
+type QueuedJob struct {
+	Project shared.ProjectName
+	Hash    string
+	Branch  string
+	Rerun   bool
+	Queued  time.Time
+
+	// The labels a runner needs to run the job. These come from the
+	// commit's benkins.toml, so they aren't known until a runner has
+	// looked at the commit.
+	RunsOn []string
+
+	State        JobState
+	Runner       string
+	Lease        string
+	LeaseExpires time.Time
+	Attempts     int
+
+	// Identifies the current attempt, for jobs to tell their runs apart.
+	// Unlike the lease, it is fine for jobs to see it.
+	RunId string
+
+	// Whether the runner holding the lease has reported that the job is
+	// running, rather than just claiming it
+	Started bool
+}
+
+// Queue holds the jobs that have not finished yet, and is the only place
+// runners get work from. A commit has at most one job in the queue at a
+// time, and a job is only handed to one runner at a time, so commits are
+// never built twice unless someone asks for a rerun. The queue is saved to
+// disk after every change so that it survives server restarts.
+type Queue struct {
+	mutex sync.Mutex
+	path  string
+	jobs  []*QueuedJob
+}
+
+type queueFile struct {
+	Jobs []QueuedJob
+}
+
+func LoadQueue(path string) (*Queue, error) {
+	q := &Queue{
+		path: path,
+	}
+
+	queueBytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return q, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var file queueFile
+	err = toml.Unmarshal(queueBytes, &file)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range file.Jobs {
+		q.jobs = append(q.jobs, &file.Jobs[i])
+	}
+
+	return q, nil
+}
+
+// Enqueue adds a job for a commit unless one is already queued or running.
+// It returns the commit's job and whether it was newly added.
+func (q *Queue) Enqueue(project shared.ProjectName, hash, branch string, rerun bool) (QueuedJob, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if job := q.find(project, hash); job != nil {
+		return *job, false
+	}
+
+	job := &QueuedJob{
+		Project: project,
+		Hash:    hash,
+		Branch:  branch,
+		Rerun:   rerun,
+		Queued:  time.Now(),
+		State:   JobQueued,
+	}
+	q.jobs = append(q.jobs, job)
+	q.save()
+
+	return *job, true
+}
+
+// Claim hands the oldest queued job for one of the given projects that a
+// runner with the given labels may run to a runner, leasing it to that runner
+// until the lease expires. It returns false if there is nothing to do.
+func (q *Queue) Claim(runner string, projects []shared.ProjectName, labels []string) (QueuedJob, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for _, job := range q.jobs {
+		if job.State != JobQueued || !containsProject(projects, job.Project) || !shared.LabelsMatch(job.RunsOn, labels) {
+			continue
+		}
+
+		job.State = JobRunning
+		job.Runner = runner
+		job.Lease = newRandomId()
+		job.LeaseExpires = time.Now().Add(LeaseDuration)
+		job.Attempts++
+		job.RunId = newRandomId()
+		job.Started = false
+		q.save()
+
+		return *job, true
+	}
+
+	return QueuedJob{}, false
+}
+
+// Release puts a job back in the queue because the runner holding lease
+// found it needs the labels in runsOn, which the runner doesn't have, or
+// because the runner is shutting down. This doesn't count as an attempt. It
+// returns the job as it was, or false if the lease is not current.
+func (q *Queue) Release(project shared.ProjectName, hash, lease string, runsOn []string) (QueuedJob, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	job := q.find(project, hash)
+	if job == nil || job.State != JobRunning || job.Lease != lease {
+		return QueuedJob{}, false
+	}
+	released := *job
+
+	job.State = JobQueued
+	job.Runner = ""
+	job.Lease = ""
+	job.LeaseExpires = time.Time{}
+	job.Attempts--
+	job.RunsOn = runsOn
+	job.Started = false
+	q.save()
+
+	return released, true
+}
+
+// Renew extends the leases the runner says it is still holding. Jobs the
+// runner has stopped working on are left to expire.
+func (q *Queue) Renew(runner string, leases []string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	renewed := false
+	for _, job := range q.jobs {
+		if job.State == JobRunning && job.Runner == runner && containsString(leases, job.Lease) {
+			job.LeaseExpires = time.Now().Add(LeaseDuration)
+			renewed = true
+		}
+	}
+
+	if renewed {
+		q.save()
+	}
+}
+
+// Expire takes back every job whose lease has run out, which means its runner
+// has stopped checking in. Jobs that may be retried go back in the queue, and
+// the rest are dropped. It returns the jobs as they were before they expired.
+func (q *Queue) Expire(maxRetries int) []QueuedJob {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	var expired []QueuedJob
+	var remaining []*QueuedJob
+	for _, job := range q.jobs {
+		if job.State != JobRunning || time.Now().Before(job.LeaseExpires) {
+			remaining = append(remaining, job)
+			continue
+		}
+
+		expired = append(expired, *job)
+
+		if job.CanRetry(maxRetries) {
+			job.State = JobQueued
+			job.Runner = ""
+			job.Lease = ""
+			job.LeaseExpires = time.Time{}
+			job.Started = false
+			remaining = append(remaining, job)
+		}
+	}
+
+	if len(expired) > 0 {
+		q.jobs = remaining
+		q.save()
+	}
+
+	return expired
+}
+
+// CanRetry reports whether the job may be run again after its latest attempt
+// was lost.
+func (j QueuedJob) CanRetry(maxRetries int) bool {
+	return j.Attempts <= maxRetries
+}
+
+// CheckLease reports whether a runner holding lease may report results for
+// a commit. Only the runner holding the current lease on the commit's job
+// may, so a runner whose lease expired can't overwrite the results of the
+// job's next attempt, even once that attempt has finished.
+func (q *Queue) CheckLease(project shared.ProjectName, hash, lease string) bool {
+	_, ok := q.LeaseHolder(project, hash, lease)
+	return ok
+}
+
+// LeaseHolder returns a commit's job if lease is its current lease.
+func (q *Queue) LeaseHolder(project shared.ProjectName, hash, lease string) (QueuedJob, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	job := q.find(project, hash)
+	if job == nil || job.State != JobRunning || lease == "" || job.Lease != lease {
+		return QueuedJob{}, false
+	}
+
+	return *job, true
+}
+
+// Start records that a commit's job is running, once the runner holding its
+// lease reports so. It returns the job, and whether this is the first report
+// for the current attempt.
+func (q *Queue) Start(project shared.ProjectName, hash string) (QueuedJob, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	job := q.find(project, hash)
+	if job == nil || job.State != JobRunning {
+		return QueuedJob{}, false
+	}
+	if job.Started {
+		return *job, false
+	}
+
+	job.Started = true
+	q.save()
+
+	return *job, true
+}
+
+// Complete removes a commit's job from the queue once its final results are
+// in. It returns the job as it was, if there was one.
+func (q *Queue) Complete(project shared.ProjectName, hash string) (QueuedJob, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for i, job := range q.jobs {
+		if job.Project == project && job.Hash == hash {
+			q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+			q.save()
+			return *job, true
+		}
+	}
+
+	return QueuedJob{}, false
+}
+
+// Jobs returns a copy of every job in the queue, oldest first.
+func (q *Queue) Jobs() []QueuedJob {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	var result []QueuedJob
+	for _, job := range q.jobs {
+		result = append(result, *job)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Queued.Before(result[j].Queued)
+	})
+
+	return result
+}
+
+func (q *Queue) find(project shared.ProjectName, hash string) *QueuedJob {
+	for _, job := range q.jobs {
+		if job.Project == project && job.Hash == hash {
+			return job
+		}
+	}
+
+	return nil
+}
+
+// save writes the queue to disk. The caller must hold the mutex.
+func (q *Queue) save() {
+	var file queueFile
+	for _, job := range q.jobs {
+		file.Jobs = append(file.Jobs, *job)
+	}
+
+	queueBytes, err := toml.Marshal(file)
+	if err == nil {
+		err = ioutil.WriteFile(q.path, queueBytes, 0644)
+	}
+	if err != nil {
+		fmt.Printf("WARNING: failed to save job queue: %v\n", err)
+	}
+}
+
+func containsProject(projects []shared.ProjectName, project shared.ProjectName) bool {
+	for _, p := range projects {
+		if p == project {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsString(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+
+	return false
+}
+
+func newRandomId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/frc-2175/benkins/shared"
+	"github.com/gin-gonic/gin"
+)
+
+func newTestQueue(t *testing.T) (*Queue, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "benkins-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queue, err := LoadQueue(filepath.Join(dir, QueueFilename))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return queue, func() { os.RemoveAll(dir) }
+}
+
+// expireLeases makes every running job's lease run out now.
+func expireLeases(queue *Queue) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	for _, job := range queue.jobs {
+		job.LeaseExpires = time.Now().Add(-time.Second)
+	}
+}
+
+func TestStaleLeaseRejected(t *testing.T) {
+	queue, cleanup := newTestQueue(t)
+	defer cleanup()
+
+	project := shared.NewProjectNameFromPlain("frc-2175/robot")
+	projects := []shared.ProjectName{project}
+	hash := "6d7cf38d6505a4209fc3cd045667c3c72f09f1a8"
+
+	queue.Enqueue(project, hash, "main", false)
+
+	lost, ok := queue.Claim("laptop", projects, nil)
+	if !ok {
+		t.Fatal("couldn't claim the job")
+	}
+
+	expireLeases(queue)
+	if expired := queue.Expire(1); len(expired) != 1 {
+		t.Fatalf("%d jobs expired, expected 1", len(expired))
+	}
+
+	retry, ok := queue.Claim("desktop", projects, nil)
+	if !ok {
+		t.Fatal("couldn't claim the job again after it expired")
+	}
+
+	if queue.CheckLease(project, hash, lost.Lease) {
+		t.Error("the lost runner's lease was accepted while the job was being retried")
+	}
+	if !queue.CheckLease(project, hash, retry.Lease) {
+		t.Error("the retrying runner's lease was turned away")
+	}
+
+	queue.Complete(project, hash)
+
+	for name, lease := range map[string]string{
+		"stale lease":    lost.Lease,
+		"finished lease": retry.Lease,
+		"missing lease":  "",
+		"made up lease":  "stale-lease",
+	} {
+		if queue.CheckLease(project, hash, lease) {
+			t.Errorf("%s was accepted after the job finished", name)
+		}
+	}
+
+	// A stale upload is turned away before it can touch the results
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	uploaded := false
+	r.POST("api/:project/:hash/artifacts", RequireLease(queue), func(c *gin.Context) {
+		uploaded = true
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest("POST", "/api/"+project.Encoded()+"/"+hash+"/artifacts", nil)
+	req.Header.Set(shared.LeaseHeader, lost.Lease)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusGone || uploaded {
+		t.Errorf("stale upload got status %d, expected %d", w.Code, http.StatusGone)
+	}
+}
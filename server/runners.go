@@ -0,0 +1,66 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/frc-2175/benkins/shared"
+)
+
+// RunnerInfo is what the server knows about a runner from its heartbeats.
+type RunnerInfo struct {
+	Name     string
+	Labels   []string
+	LastSeen time.Time
+}
+
+// Online reports whether the runner has checked in recently enough to still
+// hold leases.
+func (r RunnerInfo) Online() bool {
+	return time.Since(r.LastSeen) < LeaseDuration
+}
+
+var runnersMutex sync.Mutex
+var runners = map[string]RunnerInfo{}
+
+func recordHeartbeat(name string, labels []string) {
+	runnersMutex.Lock()
+	defer runnersMutex.Unlock()
+
+	runners[name] = RunnerInfo{
+		Name:     name,
+		Labels:   labels,
+		LastSeen: time.Now(),
+	}
+}
+
+// Runners returns every runner that has checked in since the server
+// started, sorted by name.
+func Runners() []RunnerInfo {
+	runnersMutex.Lock()
+	defer runnersMutex.Unlock()
+
+	var result []RunnerInfo
+	for _, runner := range runners {
+		result = append(result, runner)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+// AnyRunnerCanRun reports whether a runner that is online has the labels in
+// runsOn.
+func AnyRunnerCanRun(runners []RunnerInfo, runsOn []string) bool {
+	for _, runner := range runners {
+		if runner.Online() && shared.LabelsMatch(runsOn, runner.Labels) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,347 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/frc-2175/benkins/shared"
+	"github.com/gin-gonic/gin"
+	"github.com/pelletier/go-toml"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+const SecretsFilename = "benkins-secrets.toml"
+
+// Secret names become environment variables, so they have to look like
+// them.
+var secretNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var errNoMasterKey = errors.New("there is no master key, so no secrets have been set")
+
+// SecretStore keeps secrets for each project, encrypted with secretbox
+// using a master key that lives in its own file, outside of the tree the
+// server serves. The store reads the file for every operation, so the
+// server sees changes made with the CLI while it runs.
+type SecretStore struct {
+	mutex   sync.Mutex
+	path    string
+	keyPath string
+}
+
+type storedSecret struct {
+	Project string
+	Name    string
+	Updated time.Time
+
+	// The nonce followed by the sealed value, in base64
+	Value string
+}
+
+type secretsFile struct {
+	Secrets []storedSecret
+}
+
+// NewSecretStore makes a store for the secrets of the server at basePath.
+// The master key may not be inside basePath, since results are served from
+// there.
+func NewSecretStore(basePath, keyPath string) (*SecretStore, error) {
+	if basePath == "" {
+		return nil, errors.New("the path the server serves from is required")
+	}
+	if keyPath == "" {
+		return nil, errors.New("the path of the master key is required")
+	}
+
+	absBase, err := filepath.Abs(basePath)
+	if err != nil {
+		return nil, err
+	}
+	absKey, err := filepath.Abs(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	if rel, err := filepath.Rel(absBase, absKey); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("the master key %s must not be inside %s, which the server serves results from", absKey, absBase)
+	}
+
+	return &SecretStore{
+		path:    filepath.Join(absBase, SecretsFilename),
+		keyPath: absKey,
+	}, nil
+}
+
+// ValidateSecretName checks that a secret's name can be used as an
+// environment variable.
+func ValidateSecretName(name string) error {
+	if !secretNameRegexp.MatchString(name) {
+		return fmt.Errorf("%q is not a valid secret name; use letters, digits, and underscores", name)
+	}
+
+	return nil
+}
+
+// Set stores a secret for a project, replacing any secret of the same name.
+// The master key is created the first time a secret is set.
+func (s *SecretStore) Set(project shared.ProjectName, name, value string) error {
+	if err := ValidateSecretName(name); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key, err := s.masterKey(true)
+	if err != nil {
+		return err
+	}
+
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+	sealed := secretbox.Seal(nonce[:], []byte(value), &nonce, &key)
+
+	file, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	secret := storedSecret{
+		Project: project.Decoded(),
+		Name:    name,
+		Updated: time.Now(),
+		Value:   base64.StdEncoding.EncodeToString(sealed),
+	}
+
+	replaced := false
+	for i, existing := range file.Secrets {
+		if existing.Project == secret.Project && existing.Name == name {
+			file.Secrets[i] = secret
+			replaced = true
+		}
+	}
+	if !replaced {
+		file.Secrets = append(file.Secrets, secret)
+	}
+
+	return s.save(file)
+}
+
+// Delete removes a project's secret, returning false if there was no such
+// secret.
+func (s *SecretStore) Delete(project shared.ProjectName, name string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	file, err := s.load()
+	if err != nil {
+		return false, err
+	}
+
+	var remaining []storedSecret
+	for _, secret := range file.Secrets {
+		if secret.Project != project.Decoded() || secret.Name != name {
+			remaining = append(remaining, secret)
+		}
+	}
+	if len(remaining) == len(file.Secrets) {
+		return false, nil
+	}
+
+	file.Secrets = remaining
+	return true, s.save(file)
+}
+
+// SecretInfo describes a secret without revealing it.
+type SecretInfo struct {
+	Name    string
+	Updated time.Time
+}
+
+// List describes a project's secrets, sorted by name.
+func (s *SecretStore) List(project shared.ProjectName) ([]SecretInfo, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	file, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []SecretInfo
+	for _, secret := range file.Secrets {
+		if secret.Project == project.Decoded() {
+			infos = append(infos, SecretInfo{Name: secret.Name, Updated: secret.Updated})
+		}
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+
+	return infos, nil
+}
+
+// Get decrypts the named secrets for a project. It also returns the names
+// the project has no secret for.
+func (s *SecretStore) Get(project shared.ProjectName, names []string) (map[string]string, []string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	file, err := s.load()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	found := map[string]storedSecret{}
+	for _, secret := range file.Secrets {
+		if secret.Project == project.Decoded() {
+			found[secret.Name] = secret
+		}
+	}
+
+	values := map[string]string{}
+	var missing []string
+	var key [32]byte
+	haveKey := false
+	for _, name := range names {
+		secret, ok := found[name]
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+
+		if !haveKey {
+			key, err = s.masterKey(false)
+			if err != nil {
+				return nil, nil, err
+			}
+			haveKey = true
+		}
+
+		sealed, err := base64.StdEncoding.DecodeString(secret.Value)
+		if err != nil || len(sealed) < 24 {
+			return nil, nil, fmt.Errorf("secret %s is corrupt", name)
+		}
+
+		var nonce [24]byte
+		copy(nonce[:], sealed[:24])
+		value, ok := secretbox.Open(nil, sealed[24:], &nonce, &key)
+		if !ok {
+			return nil, nil, fmt.Errorf("failed to decrypt secret %s; is this the right master key?", name)
+		}
+
+		values[name] = string(value)
+	}
+
+	return values, missing, nil
+}
+
+// masterKey reads the master key, which is 32 bytes written in hex. If
+// create is true and there is no key yet, a new one is made.
+func (s *SecretStore) masterKey(create bool) ([32]byte, error) {
+	var key [32]byte
+
+	keyBytes, err := ioutil.ReadFile(s.keyPath)
+	if os.IsNotExist(err) {
+		if !create {
+			return key, errNoMasterKey
+		}
+
+		if _, err := rand.Read(key[:]); err != nil {
+			return key, err
+		}
+		if err := ioutil.WriteFile(s.keyPath, []byte(hex.EncodeToString(key[:])+"\n"), 0600); err != nil {
+			return key, err
+		}
+		fmt.Printf("Created a new master key at %s. Keep a copy somewhere safe; the secrets can't be read without it.\n", s.keyPath)
+
+		return key, nil
+	} else if err != nil {
+		return key, err
+	}
+
+	decoded, err := hex.DecodeString(strings.TrimSpace(string(keyBytes)))
+	if err != nil || len(decoded) != len(key) {
+		return key, fmt.Errorf("the master key in %s should be %d bytes of hex", s.keyPath, len(key))
+	}
+	copy(key[:], decoded)
+
+	return key, nil
+}
+
+func (s *SecretStore) load() (secretsFile, error) {
+	var file secretsFile
+
+	fileBytes, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return file, nil
+	} else if err != nil {
+		return file, err
+	}
+
+	err = toml.Unmarshal(fileBytes, &file)
+	return file, err
+}
+
+func (s *SecretStore) save(file secretsFile) error {
+	fileBytes, err := toml.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(s.path, fileBytes, 0600)
+}
+
+// Secrets responds with the values of the secrets named by the name query
+// parameters, as a JSON object. Only the runner holding the lease on the
+// commit's job gets them, and it only asks for the secrets the job's
+// benkins.toml uses. store is nil if the server was started without a
+// master key.
+func Secrets(queue *Queue, store *SecretStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		projectName := shared.NewProjectNameFromEncoded(c.Param("project"))
+		hash := c.Param("hash")
+
+		if store == nil {
+			c.String(http.StatusNotFound, "This server has no master key, so it has no secrets.")
+			return
+		}
+
+		job, ok := queue.LeaseHolder(projectName, hash, c.GetHeader(shared.LeaseHeader))
+		if !ok {
+			c.String(http.StatusGone, "This runner does not hold the lease on this commit's job.")
+			return
+		}
+
+		names := c.QueryArray("name")
+		values, missing, err := store.Get(projectName, names)
+		if err == errNoMasterKey {
+			missing = names
+		} else if err != nil {
+			fmt.Printf("ERROR: failed to get secrets for %s: %v\n", projectName.Decoded(), err)
+			c.String(http.StatusInternalServerError, "Failed to get secrets.")
+			return
+		}
+
+		if len(missing) > 0 {
+			c.String(http.StatusNotFound, "%s has no secrets named %s.", projectName.Decoded(), strings.Join(missing, ", "))
+			return
+		}
+
+		fmt.Printf("Runner %s fetched secrets %s for %s commit %s.\n", job.Runner, strings.Join(names, ", "), projectName.Decoded(), hash)
+		c.JSON(http.StatusOK, values)
+	}
+}
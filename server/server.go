@@ -3,11 +3,15 @@ package server
 import (
 	"bufio"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"time"
+
+	"github.com/frc-2175/benkins/notify"
+	"github.com/frc-2175/benkins/shared"
 
 	"github.com/gin-contrib/multitemplate"
 	"github.com/gin-gonic/gin"
@@ -16,13 +20,13 @@ import (
 
 const LineWidth = 100
 
-type v map[string]interface{}
+const QueueFilename = "benkins-queue.toml"
 
-var heartbeats = map[string]time.Time{}
+type v map[string]interface{}
 
 // TODO: Sanitize dots in filepath stuff everywhere
 
-func Main(basePath, password string) {
+func Main(basePath, password string, maxRetries int, masterKeyPath string) {
 	reader := bufio.NewReader(os.Stdin)
 
 	for basePath == "" {
@@ -72,17 +76,46 @@ func Main(basePath, password string) {
 
 	loader := NewLoader(basePath)
 
+	queue, err := LoadQueue(filepath.Join(basePath, QueueFilename))
+	if err != nil {
+		panic(err)
+	}
+
+	settings, err := LoadSettings(filepath.Join(basePath, SettingsFilename))
+	if err != nil {
+		panic(err)
+	}
+
+	var secrets *SecretStore
+	if masterKeyPath != "" {
+		secrets, err = NewSecretStore(basePath, masterKeyPath)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	go WatchLeases(loader, queue, maxRetries)
+
+	go SendNotifications(loader, settings)
+	go ReportToForges(loader, settings)
+	if settings.Digest != nil {
+		go WatchDigest(loader, *settings.Digest, settings.Url)
+	}
+
 	r := gin.Default()
 	r.HTMLRender = multitemplate.NewRenderer()
 
 	r.Static("/static", "server/static")
 
-	r.GET("/", Home(r, loader))
+	r.GET("/", Home(r, loader, queue))
 	r.GET("p/:project", ProjectIndex(r, loader))
-	r.GET("p/:project/:hash", CommitIndex(r, loader))
+	r.GET("p/:project/:hash", CommitIndex(r, loader, settings))
 	r.GET("p/:project/:hash/f/:file", FileIndex(r, loader))
+	r.GET("p/:project/:hash/log/stream", LogStream(r, loader))
+
+	r.POST("hooks/:forge", Webhook(loader, queue, settings))
 
-	api := r.Group("api", func(c *gin.Context) {
+	authed := func(c *gin.Context) {
 		auth := c.GetHeader("Authorization")
 		if auth != password {
 			c.AbortWithStatus(http.StatusUnauthorized)
@@ -90,28 +123,64 @@ func Main(basePath, password string) {
 		}
 
 		c.Next()
-	})
+	}
+
+	api := r.Group("api", authed)
 	{
 		api.GET("/", func(c *gin.Context) {
 			if name := c.Query("name"); name != "" {
-				heartbeats[name] = time.Now()
+				recordHeartbeat(name, c.QueryArray("label"))
+				queue.Renew(name, c.QueryArray("lease"))
 			}
 			c.AbortWithStatus(http.StatusOK)
 		})
 
 		api.GET(":project/:hash", func(c *gin.Context) {
-			projectEncoded := c.Param("project")
-			hash := c.Param("hash")
+			projectName := shared.NewProjectNameFromEncoded(c.Param("project"))
 
-			_, err := os.Stat(filepath.Join(basePath, artifactPath(projectEncoded, hash)))
-			if os.IsNotExist(err) {
+			if !commitFinished(loader, projectName, c.Param("hash")) {
 				c.AbortWithStatus(http.StatusNotFound)
 				return
 			}
 
 			c.AbortWithStatus(http.StatusOK)
 		})
-		api.POST(":project/:hash/artifacts", func(c *gin.Context) {
+		api.GET(":project/:hash/secrets", Secrets(queue, secrets))
+		api.POST(":project/:hash/log", RequireLease(queue), func(c *gin.Context) {
+			projectEncoded := c.Param("project")
+			hash := c.Param("hash")
+
+			offset, err := strconv.ParseInt(c.Query("offset"), 10, 64)
+			if err != nil || offset < 0 {
+				c.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid offset: %v", c.Query("offset")))
+				return
+			}
+
+			chunk, err := ioutil.ReadAll(c.Request.Body)
+			if err != nil {
+				c.AbortWithError(http.StatusBadRequest, err)
+				return
+			}
+
+			dstDir := filepath.Join(basePath, artifactPath(projectEncoded, hash))
+			err = os.MkdirAll(dstDir, 0755)
+			if err != nil {
+				c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("error creating artifact directory: %v", err))
+				return
+			}
+
+			size, err := AppendLog(filepath.Join(dstDir, shared.ExecutionLogFilename), offset, chunk)
+			if err == errLogOffset {
+				c.String(http.StatusConflict, "%d", size)
+				return
+			} else if err != nil {
+				c.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+
+			c.String(http.StatusOK, "%d", size)
+		})
+		api.POST(":project/:hash/artifacts", RequireLease(queue), func(c *gin.Context) {
 			projectEncoded := c.Param("project")
 			hash := c.Param("hash")
 
@@ -138,10 +207,35 @@ func Main(basePath, password string) {
 				}
 			}
 
+			// Final results finish the commit's job
+			projectName := shared.NewProjectNameFromEncoded(projectEncoded)
+			if commit, err := loader.Commit(projectName, hash); err == nil {
+				if commit.Status == shared.StatusRunning {
+					// The job is gone if its lease expired during the upload
+					job, started := queue.Start(projectName, hash)
+					if started {
+						queueNotification(notify.EventStarted, job)
+					}
+					if job.Hash != "" {
+						queueForgeReport(job)
+					}
+				} else if job, ok := queue.Complete(projectName, hash); ok {
+					queueNotification(notify.EventFinished, job)
+					queueForgeReport(job)
+				}
+			}
+
 			c.String(http.StatusOK, "Artifacts uploaded successfully.")
 		})
 	}
 
+	queueApi := r.Group("queue", authed)
+	{
+		queueApi.POST("", EnqueueJob(loader, queue))
+		queueApi.POST("claim", ClaimJob(queue))
+		queueApi.POST("release", ReleaseJob(queue))
+	}
+
 	if err := r.Run(":8080"); err != nil {
 		panic(err)
 	}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/frc-2175/benkins/forge"
+	"github.com/frc-2175/benkins/notify"
+	"github.com/frc-2175/benkins/shared"
+	"github.com/pelletier/go-toml"
+)
+
+const SettingsFilename = "benkins-server.toml"
+
+// Settings is the server's configuration, read from benkins-server.toml in
+// the base path.
+type Settings struct {
+	// Url is the server's public URL, for links in notifications.
+	Url string `toml:"url"`
+
+	Projects []ProjectSettings `toml:"projects"`
+
+	// Notify sets up notifiers for every project. Projects can add their
+	// own.
+	Notify notify.Config `toml:"notify"`
+
+	// Digest sends a daily email about every project's builds, if set.
+	Digest *DigestSettings `toml:"digest"`
+}
+
+type ProjectSettings struct {
+	// Name is the project's name as shown by Benkins, which runners derive
+	// from the repo URL unless their config overrides it.
+	Name string `toml:"name"`
+
+	// Repo is the repo's full name on the forge, like "frc-2175/robot".
+	// Webhooks are matched to projects by this name.
+	Repo string `toml:"repo"`
+
+	// WebhookSecret is the secret shared with the forge for signing
+	// webhooks. Webhooks for projects with no secret are rejected.
+	WebhookSecret string `toml:"webhook_secret"`
+
+	// Notify sets up notifiers for this project, in addition to the ones
+	// for every project.
+	Notify notify.Config `toml:"notify"`
+
+	// The forge the repo is hosted on, if jobs should be reported to it
+	// as commit statuses or check runs. At most one should be set. The
+	// forge's repo defaults to Repo.
+	GitHub *forge.Config `toml:"github"`
+	Gitea  *forge.Config `toml:"gitea"`
+}
+
+// Forge returns the forge the project is hosted on and its config, or nil
+// if the project isn't set up for one.
+func (p ProjectSettings) Forge() (forge.Forge, forge.Config) {
+	var kind string
+	var config forge.Config
+	switch {
+	case p.GitHub != nil:
+		kind, config = forge.KindGitHub, *p.GitHub
+	case p.Gitea != nil:
+		kind, config = forge.KindGitea, *p.Gitea
+	default:
+		return nil, forge.Config{}
+	}
+
+	if config.Repo == "" {
+		config.Repo = p.Repo
+	}
+
+	f, err := forge.New(kind, config)
+	if err != nil {
+		fmt.Printf("WARNING: %v\n", err)
+		return nil, forge.Config{}
+	}
+
+	return f, config
+}
+
+// LoadSettings reads the settings file at path. A missing file is the same
+// as an empty one.
+func LoadSettings(path string) (Settings, error) {
+	var settings Settings
+
+	settingsBytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return settings, nil
+	} else if err != nil {
+		return settings, err
+	}
+
+	err = toml.Unmarshal(settingsBytes, &settings)
+	if err != nil {
+		return settings, err
+	}
+
+	return settings, nil
+}
+
+// ProjectForRepo finds the project for a repo's full name on the forge.
+func (s Settings) ProjectForRepo(repo string) (ProjectSettings, bool) {
+	for _, project := range s.Projects {
+		if project.Repo == repo {
+			return project, true
+		}
+	}
+
+	return ProjectSettings{}, false
+}
+
+// Project finds the settings for a project by its name.
+func (s Settings) Project(projectName shared.ProjectName) (ProjectSettings, bool) {
+	for _, project := range s.Projects {
+		if project.Name == projectName.Decoded() {
+			return project, true
+		}
+	}
+
+	return ProjectSettings{}, false
+}
+
+// CommitUrl links to a commit on its project's forge, or is empty if the
+// project isn't set up for one.
+func (s Settings) CommitUrl(projectName shared.ProjectName, hash string) string {
+	project, ok := s.Project(projectName)
+	if !ok {
+		return ""
+	}
+
+	f, _ := project.Forge()
+	if f == nil {
+		return ""
+	}
+
+	return f.CommitUrl(hash)
+}
+
+// Notifiers creates the notifiers for a project's builds.
+func (s Settings) Notifiers(projectName shared.ProjectName) []notify.Notifier {
+	notifiers := s.Notify.Notifiers()
+	for _, project := range s.Projects {
+		if project.Name == projectName.Decoded() {
+			notifiers = append(notifiers, project.Notify.Notifiers()...)
+		}
+	}
+
+	return notifiers
+}
@@ -0,0 +1,141 @@
+package shared
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type AnnotationLevel string
+
+// These match the annotation levels of GitHub check runs.
+const (
+	AnnotationNotice  AnnotationLevel = "notice"
+	AnnotationWarning AnnotationLevel = "warning"
+	AnnotationFailure AnnotationLevel = "failure"
+)
+
+// The most annotations kept for a single step, so that a step that spews
+// errors doesn't bloat its results
+const MaxStepAnnotations = 50
+
+// Annotation points at a line of a file in the repo that a step complained
+// about.
+type Annotation struct {
+	Path    string
+	Line    int
+	Column  int
+	Level   AnnotationLevel
+	Message string
+}
+
+// Matches lines like the ones compilers, linters, and test runners print,
+// such as:
+//
+//	src/main/java/frc/robot/Robot.java:42: error: ';' expected
+//	main.go:10:2: undefined: x
+//	lib/foo.c:3:14: warning: unused variable 'y'
+//	C:\Users\FRC Team\robot\src\main\java\frc\robot\Robot.java:42: error: ';' expected
+var annotationRegexp = regexp.MustCompile(`^\s*((?:[A-Za-z]:)?[^:]+?):(\d+):(?:(\d+):)?\s*(?:(fatal error|error|warning|note|info)\s*:\s*)?(.+)$`)
+
+// Matches the start of an absolute Windows path, once its slashes have been
+// turned around
+var driveRegexp = regexp.MustCompile(`^[A-Za-z]:/`)
+
+// statFile checks the files annotations point at. Tests replace it, since
+// the paths in their output don't exist.
+var statFile = os.Stat
+
+// ParseAnnotations finds the lines of a step's output that point at files
+// in the workspace. Paths may be absolute or relative to the directory the
+// step ran in, and are reported relative to the workspace. Lines that don't
+// say how serious they are count as failures if the step failed, and
+// notices otherwise.
+func ParseAnnotations(output, workspace, stepDir string, stepFailed bool) []Annotation {
+	var annotations []Annotation
+
+	for _, line := range strings.Split(output, "\n") {
+		match := annotationRegexp.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if match == nil {
+			continue
+		}
+
+		path, ok := workspacePath(strings.TrimSpace(match[1]), workspace, stepDir)
+		if !ok {
+			continue
+		}
+
+		lineNumber, _ := strconv.Atoi(match[2])
+		column, _ := strconv.Atoi(match[3])
+
+		level := AnnotationNotice
+		switch match[4] {
+		case "fatal error", "error":
+			level = AnnotationFailure
+		case "warning":
+			level = AnnotationWarning
+		case "note", "info":
+			level = AnnotationNotice
+		default:
+			if stepFailed {
+				level = AnnotationFailure
+			}
+		}
+
+		annotations = append(annotations, Annotation{
+			Path:    path,
+			Line:    lineNumber,
+			Column:  column,
+			Level:   level,
+			Message: strings.TrimSpace(match[5]),
+		})
+
+		if len(annotations) >= MaxStepAnnotations {
+			break
+		}
+	}
+
+	return annotations
+}
+
+// workspacePath makes a path from a step's output relative to the
+// workspace, and reports whether it names a file in the workspace. Paths are
+// compared with forward slashes, so Windows paths work with either kind of
+// slash.
+func workspacePath(p, workspace, stepDir string) (string, bool) {
+	p = slashPath(p)
+	if !strings.HasPrefix(p, "/") && !driveRegexp.MatchString(p) {
+		p = path.Join(slashPath(stepDir), p)
+	}
+	p = path.Clean(p)
+
+	prefix := path.Clean(slashPath(workspace)) + "/"
+	if len(p) <= len(prefix) {
+		return "", false
+	}
+
+	// Windows doesn't care about the case of paths
+	if driveRegexp.MatchString(prefix) {
+		if !strings.EqualFold(p[:len(prefix)], prefix) {
+			return "", false
+		}
+	} else if p[:len(prefix)] != prefix {
+		return "", false
+	}
+
+	rel := p[len(prefix):]
+	info, err := statFile(filepath.Join(workspace, filepath.FromSlash(rel)))
+	if err != nil || info.IsDir() {
+		return "", false
+	}
+
+	return rel, true
+}
+
+// slashPath turns the backslashes in a Windows path into forward slashes.
+func slashPath(p string) string {
+	return strings.Replace(p, "\\", "/", -1)
+}
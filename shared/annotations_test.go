@@ -0,0 +1,145 @@
+package shared
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeFileInfo struct {
+	name string
+}
+
+func (f fakeFileInfo) Name() string       { return f.name }
+func (f fakeFileInfo) Size() int64        { return 0 }
+func (f fakeFileInfo) Mode() os.FileMode  { return 0644 }
+func (f fakeFileInfo) ModTime() time.Time { return time.Time{} }
+func (f fakeFileInfo) IsDir() bool        { return false }
+func (f fakeFileInfo) Sys() interface{}   { return nil }
+
+// The files that exist as far as the tests are concerned, with forward
+// slashes
+var annotationTestFiles = map[string]bool{
+	"/home/runner/robot/src/main/java/frc/robot/Robot.java":      true,
+	"/home/runner/robot/app/main.go":                             true,
+	"/home/runner/robot/lib/foo.c":                               true,
+	"/usr/include/stdio.h":                                       true,
+	"C:/Users/FRC Team/robot/src/main/java/frc/robot/Robot.java": true,
+}
+
+func fakeStat(name string) (os.FileInfo, error) {
+	if !annotationTestFiles[strings.Replace(name, "\\", "/", -1)] {
+		return nil, os.ErrNotExist
+	}
+
+	return fakeFileInfo{name: name}, nil
+}
+
+func TestParseAnnotations(t *testing.T) {
+	statFile = fakeStat
+	defer func() { statFile = os.Stat }()
+
+	const linux = "/home/runner/robot"
+	const windows = `C:\Users\FRC Team\robot`
+
+	tests := []struct {
+		name       string
+		workspace  string
+		stepDir    string
+		output     string
+		stepFailed bool
+		expected   []Annotation
+	}{
+		{
+			name:      "linux absolute path",
+			workspace: linux,
+			stepDir:   linux,
+			output:    "/home/runner/robot/src/main/java/frc/robot/Robot.java:42: error: ';' expected\n",
+			expected: []Annotation{
+				{Path: "src/main/java/frc/robot/Robot.java", Line: 42, Level: AnnotationFailure, Message: "';' expected"},
+			},
+		},
+		{
+			name:       "relative to the step's directory",
+			workspace:  linux,
+			stepDir:    linux + "/app",
+			output:     "main.go:10:2: undefined: x\n",
+			stepFailed: true,
+			expected: []Annotation{
+				{Path: "app/main.go", Line: 10, Column: 2, Level: AnnotationFailure, Message: "undefined: x"},
+			},
+		},
+		{
+			name:      "gcc file:line:col",
+			workspace: linux,
+			stepDir:   linux,
+			output:    "lib/foo.c:3:14: warning: unused variable 'y'\n",
+			expected: []Annotation{
+				{Path: "lib/foo.c", Line: 3, Column: 14, Level: AnnotationWarning, Message: "unused variable 'y'"},
+			},
+		},
+		{
+			name:      "windows absolute path",
+			workspace: windows,
+			stepDir:   windows,
+			output:    "C:\\Users\\FRC Team\\robot\\src\\main\\java\\frc\\robot\\Robot.java:42: error: ';' expected\r\n",
+			expected: []Annotation{
+				{Path: "src/main/java/frc/robot/Robot.java", Line: 42, Level: AnnotationFailure, Message: "';' expected"},
+			},
+		},
+		{
+			name:      "windows path with a different case",
+			workspace: windows,
+			stepDir:   windows,
+			output:    "c:\\users\\frc team\\robot\\src\\main\\java\\frc\\robot\\Robot.java:7: warning: [deprecation] old\r\n",
+			expected: []Annotation{
+				{Path: "src/main/java/frc/robot/Robot.java", Line: 7, Level: AnnotationWarning, Message: "[deprecation] old"},
+			},
+		},
+		{
+			name:      "windows relative path",
+			workspace: windows,
+			stepDir:   windows,
+			output:    "src\\main\\java\\frc\\robot\\Robot.java:42: something odd\r\n",
+			expected: []Annotation{
+				{Path: "src/main/java/frc/robot/Robot.java", Line: 42, Level: AnnotationNotice, Message: "something odd"},
+			},
+		},
+		{
+			name:       "lines that don't point at files",
+			workspace:  linux,
+			stepDir:    linux,
+			stepFailed: true,
+			output: strings.Join([]string{
+				"> Task :compileJava",
+				"Note: Some input files use unchecked or unsafe operations.",
+				"\tat frc.robot.Robot.main(Robot.java:42)",
+				"See http://example.com:8080/docs for help",
+				"12:34:56 starting",
+				"BUILD FAILED in 3s",
+			}, "\n"),
+		},
+		{
+			name:       "files outside the workspace or missing",
+			workspace:  linux,
+			stepDir:    linux,
+			stepFailed: true,
+			output: strings.Join([]string{
+				"/usr/include/stdio.h:12: error: conflicting types",
+				"../robot/../../etc/passwd:1: error: nope",
+				"src/Missing.java:1: error: cannot find symbol",
+			}, "\n"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			annotations := ParseAnnotations(test.output, test.workspace, test.stepDir, test.stepFailed)
+			if !reflect.DeepEqual(annotations, test.expected) {
+				t.Errorf("got %+v, expected %+v", annotations, test.expected)
+			}
+		})
+	}
+}
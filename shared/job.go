@@ -0,0 +1,19 @@
+package shared
+
+import "time"
+
+// LeaseHeader carries the lease of a claimed job on every upload the runner
+// makes for it, so the server can turn away runners that lost their lease.
+const LeaseHeader = "X-Benkins-Lease"
+
+// ClaimedJob is what the server sends a runner when the runner claims a job.
+type ClaimedJob struct {
+	Project      ProjectName `json:"project"`
+	Hash         string      `json:"hash"`
+	Branch       string      `json:"branch"`
+	Rerun        bool        `json:"rerun"`
+	Attempt      int         `json:"attempt"`
+	RunId        string      `json:"runId"`
+	Lease        string      `json:"lease"`
+	LeaseExpires time.Time   `json:"leaseExpires"`
+}
@@ -0,0 +1,21 @@
+package shared
+
+// LabelsMatch reports whether a runner with the given labels may run a job
+// that requires the labels in runsOn.
+func LabelsMatch(runsOn, labels []string) bool {
+	for _, required := range runsOn {
+		found := false
+		for _, label := range labels {
+			if label == required {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
@@ -1,6 +1,8 @@
 package shared
 
 import (
+	"time"
+
 	"github.com/pelletier/go-toml"
 )
 
@@ -10,10 +12,54 @@ const (
 	NotificationFilename = "benkins-notification.txt"
 )
 
+type Status string
+
+const (
+	StatusSuccess  Status = "success"
+	StatusFailure  Status = "failure"
+	StatusSkipped  Status = "skipped"
+	StatusTimedOut Status = "timed out"
+	StatusRunning  Status = "running"
+	StatusErrored  Status = "errored"
+)
+
 type JobResults struct {
 	Success       bool
+	Status        Status
 	CommitMessage string
+	Author        string
+	AuthorEmail   string
 	BranchName    string
+	Duration      time.Duration
+	Steps         []StepResult
+
+	// Name is the job's name from benkins.toml, which its commit statuses
+	// are reported under.
+	Name string
+
+	// TotalSteps is how many steps the job has, including ones that
+	// haven't run yet.
+	TotalSteps int
+
+	// Why the job could not be run, if its status is errored
+	Error string
+
+	// Notification is custom text for notifications, which the job can
+	// leave in benkins-notification.txt.
+	Notification string
+
+	// NotifyArtifacts are the uploaded artifacts to attach to
+	// notifications.
+	NotifyArtifacts []string
+}
+
+type StepResult struct {
+	Name            string
+	Status          Status
+	ExitCode        int
+	Duration        time.Duration
+	ContinueOnError bool
+	Annotations     []Annotation
 }
 
 func (r JobResults) ToTOML() string {